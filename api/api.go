@@ -0,0 +1,217 @@
+package api
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+type Client struct {
+	Addr  string
+	Token string
+	HTTP  *http.Client
+}
+
+type ACLToken struct {
+	AccessorID string `json:",omitempty"`
+	SecretID   string `json:",omitempty"`
+	Name       string
+	Type       string
+	Policies   []string
+}
+
+type ACLPolicy struct {
+	Name        string
+	Description string
+	Rules       string
+}
+
+type Node struct {
+	ID                    string
+	Name                  string
+	Datacenter            string
+	NodePool              string
+	Status                string
+	SchedulingEligibility string
+	Drain                 bool
+	Version               string
+	ModifyIndex           uint64
+}
+
+type DrainSpec struct {
+	Deadline         time.Duration
+	IgnoreSystemJobs bool
+}
+
+type Member struct {
+	Name   string
+	Addr   string
+	Port   int
+	Status string
+	Tags   map[string]string
+}
+
+type drainRequest struct {
+	DrainSpec    *DrainSpec
+	MarkEligible bool
+}
+
+type eligibilityRequest struct {
+	Eligibility string
+}
+
+type keyring struct {
+	Key string
+}
+
+type keyringList struct {
+	Keys     map[string]int
+	NumNodes int
+}
+
+type members struct {
+	ServerName   string
+	ServerRegion string
+	ServerDC     string
+	Members      []Member
+}
+
+func NewClient(addr string) *Client {
+	return &Client{
+		Addr: addr,
+		HTTP: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (c *Client) SetTLS(caFile string, certFile string, keyFile string) error {
+	ca, err := os.ReadFile(caFile)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return errors.New("cannot read ca " + caFile)
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	c.HTTP.Transport = &http.Transport{
+		TLSClientConfig: &tls.Config{
+			RootCAs:      pool,
+			Certificates: []tls.Certificate{cert},
+		},
+	}
+	return nil
+}
+
+func (c *Client) Leader() (leader string, err error) {
+	err = c.Get("/v1/status/leader", &leader)
+	return leader, err
+}
+
+func (c *Client) Nodes() (nodes []Node, err error) {
+	err = c.Get("/v1/nodes", &nodes)
+	return nodes, err
+}
+
+func (c *Client) Members() ([]Member, error) {
+	var m members
+	err := c.Get("/v1/agent/members", &m)
+	return m.Members, err
+}
+
+func (c *Client) BootstrapACL() (token ACLToken, err error) {
+	err = c.Put("/v1/acl/bootstrap", nil, &token)
+	return token, err
+}
+
+func (c *Client) UpsertACLPolicy(policy ACLPolicy) error {
+	return c.Put("/v1/acl/policy/"+policy.Name, policy, nil)
+}
+
+func (c *Client) CreateACLToken(token ACLToken) (created ACLToken, err error) {
+	err = c.Put("/v1/acl/token", token, &created)
+	return created, err
+}
+
+func (c *Client) DrainNode(id string, spec *DrainSpec) error {
+	// a nil spec cancels the drain and lets the node take work again
+	return c.Put("/v1/node/"+id+"/drain", drainRequest{DrainSpec: spec, MarkEligible: spec == nil}, nil)
+}
+
+func (c *Client) SetEligibility(id string, eligible bool) error {
+	eligibility := "ineligible"
+	if eligible {
+		eligibility = "eligible"
+	}
+	return c.Put("/v1/node/"+id+"/eligibility", eligibilityRequest{Eligibility: eligibility}, nil)
+}
+
+func (c *Client) PurgeNode(id string) error {
+	return c.Put("/v1/node/"+id+"/purge", nil, nil)
+}
+
+func (c *Client) KeyringList() (keys map[string]int, err error) {
+	var list keyringList
+	err = c.Get("/v1/agent/keyring/list", &list)
+	return list.Keys, err
+}
+
+func (c *Client) KeyringInstall(key string) error {
+	return c.Put("/v1/agent/keyring/install", keyring{Key: key}, nil)
+}
+
+func (c *Client) KeyringUse(key string) error {
+	return c.Put("/v1/agent/keyring/use", keyring{Key: key}, nil)
+}
+
+func (c *Client) KeyringRemove(key string) error {
+	return c.Put("/v1/agent/keyring/remove", keyring{Key: key}, nil)
+}
+
+func (c *Client) Get(path string, out interface{}) error {
+	return c.Do(http.MethodGet, path, nil, out)
+}
+
+func (c *Client) Put(path string, in interface{}, out interface{}) error {
+	return c.Do(http.MethodPut, path, in, out)
+}
+
+func (c *Client) Do(method string, path string, in interface{}, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, c.Addr+path, body)
+	if err != nil {
+		return err
+	}
+	if c.Token != "" {
+		req.Header.Set("X-Nomad-Token", c.Token)
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return errors.New(method + " " + path + " returned " + strconv.Itoa(resp.StatusCode) + ": " + string(bytes.TrimSpace(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,151 @@
+// Package box runs a nomad-box cluster from Go, for example from a TestMain:
+//
+//	spec := config.Defaults()
+//	spec.Servers, spec.Clients = 1, 2
+//	cluster := box.New(spec)
+//	if err := cluster.Start(ctx); err != nil {
+//		log.Fatal(err)
+//	}
+//	defer cluster.Stop()
+//	cluster.Node("c1").Kill()
+//
+// Errors are returned rather than exiting. Progress is still reported on
+// run.Output, set it to io.Discard for a quiet run. Only one cluster can run
+// in a process at a time.
+package box
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/mmcquillan/nomad-box/checks"
+	"github.com/mmcquillan/nomad-box/config"
+	"github.com/mmcquillan/nomad-box/node"
+)
+
+type Cluster struct {
+	spec    config.Config
+	state   *node.State
+	token   string
+	stop    *sync.Once
+	stopErr error
+}
+
+type Node struct {
+	cluster *Cluster
+	name    string
+}
+
+// New makes a cluster from a spec, config.Defaults with config.LoadSpec is a good start
+func New(spec config.Config) *Cluster {
+	return &Cluster{spec: spec}
+}
+
+// Start builds the cluster and waits until it is ready, cancelling ctx stops it.
+// A failed start leaves nothing behind and can be tried again.
+func (c *Cluster) Start(ctx context.Context) error {
+
+	if c.state != nil {
+		return errors.New("cluster already started")
+	}
+
+	// always attached so agents are supervised by this process
+	cfg := c.spec
+	cfg.Command = "up"
+	cfg.Detach = false
+	cfg.Plan = false
+	cfg.Clean = false
+	cfg.Control = ""
+	if cfg.Spec != "" {
+		if err := config.LoadSpec(cfg.Spec, &cfg); err != nil {
+			return err
+		}
+	}
+	if err := config.Resolve(&cfg); err != nil {
+		return err
+	}
+	if err := checks.Checks(&cfg); err != nil {
+		return err
+	}
+	if err := node.CheckRunning(cfg); err != nil {
+		return err
+	}
+
+	// start the nodes, tearing them down again if they never get ready
+	state := &node.State{Config: cfg, Nodes: node.MakeNodes(cfg)}
+	node.Attach(state)
+	token, err := node.StartNodes(ctx, cfg, state.Nodes)
+	if err != nil {
+		var phase *node.PhaseError
+		if !errors.As(err, &phase) || phase.Phase == node.PhaseReady {
+			// a failed build is rolled back by StartNodes, the rest is cleaned here
+			err = errors.Join(err, node.CleanNodes(cfg, state.Nodes))
+		}
+		node.Attach(nil)
+		return err
+	}
+	c.state = state
+	c.token = token
+	c.stop = &sync.Once{}
+
+	// stop with the context
+	go func() {
+		<-ctx.Done()
+		c.Stop()
+	}()
+	return nil
+}
+
+// Stop stops and cleans the cluster, it is safe to call more than once and returns what could not be cleaned
+func (c *Cluster) Stop() error {
+	if c.state == nil {
+		return errors.New("cluster not started")
+	}
+	c.stop.Do(func() {
+		c.stopErr = node.CleanNodes(c.state.Config, c.state.Nodes)
+		node.Attach(nil)
+	})
+	return c.stopErr
+}
+
+// Addr is the http address of a server for NOMAD_ADDR
+func (c *Cluster) Addr() string {
+	if c.state == nil {
+		return ""
+	}
+	return node.Addr(c.state.Config, c.state.Nodes)
+}
+
+// Token is the management token when the spec enables ACLs
+func (c *Cluster) Token() string {
+	return c.token
+}
+
+// Node is a handle on a node by name, with or without the prefix (c1 or nmdc1)
+func (c *Cluster) Node(name string) *Node {
+	return &Node{cluster: c, name: name}
+}
+
+func (n *Node) Kill() error {
+	return n.action("kill")
+}
+
+func (n *Node) Stop() error {
+	return n.action("stop")
+}
+
+func (n *Node) Start() error {
+	return n.action("start")
+}
+
+func (n *Node) Restart() error {
+	return n.action("restart")
+}
+
+func (n *Node) action(action string) error {
+	if n.cluster.state == nil {
+		return errors.New("cluster not started")
+	}
+	return node.NodeAction(n.cluster.state, n.name, action)
+}
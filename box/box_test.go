@@ -0,0 +1,130 @@
+package box
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"strings"
+	"testing"
+
+	"github.com/mmcquillan/nomad-box/config"
+	"github.com/mmcquillan/nomad-box/network"
+	"github.com/mmcquillan/nomad-box/run"
+)
+
+func TestMain(m *testing.M) {
+	run.Output = io.Discard
+	os.Exit(m.Run())
+}
+
+// flakyLinks fails to address the device named fail the first time and cannot delete the device named stuck
+type flakyLinks struct {
+	*network.Fake
+	fail   string
+	failed *bool
+	stuck  string
+}
+
+func (f flakyLinks) AddAddr(name string, cidr string, label string) error {
+	if name == f.fail && !*f.failed {
+		*f.failed = true
+		return &network.LinkError{Op: "add addr", Link: name, Err: errors.New("no room")}
+	}
+	return f.Fake.AddAddr(name, cidr, label)
+}
+
+func (f flakyLinks) DelLink(name string) error {
+	if name == f.stuck {
+		return &network.LinkError{Op: "del link", Link: name, Err: errors.New("busy")}
+	}
+	return f.Fake.DelLink(name)
+}
+
+// testSpec is one server and one client that run nothing, the pre checks still need root and ip
+func testSpec(t *testing.T, links network.Backend) config.Config {
+	t.Helper()
+	if u, err := user.Current(); err != nil || u.Username != "root" {
+		t.Skip("the pre checks need root")
+	}
+	if _, err := exec.LookPath("ip"); err != nil {
+		t.Skip("the pre checks need ip")
+	}
+	binary := t.TempDir() + "/nomad"
+	if err := os.WriteFile(binary, nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+	spec := config.Defaults()
+	spec.Servers = 1
+	spec.Clients = 1
+	spec.Binary = binary
+	spec.Directory = t.TempDir()
+	spec.ReadyTimeout = 0
+	spec.Runner = &run.Recorder{}
+	spec.Links = links
+	return spec
+}
+
+func TestStartRetry(t *testing.T) {
+	fake := network.NewFake()
+	failed := false
+	cluster := New(testSpec(t, flakyLinks{Fake: fake, fail: "nmdeth1", failed: &failed}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// a failed build leaves nothing behind and no started cluster
+	if err := cluster.Start(ctx); err == nil || !strings.Contains(err.Error(), "nmdeth1") {
+		t.Fatalf("first start is %v, want a network error for nmdeth1", err)
+	}
+	if len(fake.Links) != 0 {
+		t.Errorf("%d links left after a failed start", len(fake.Links))
+	}
+	if cluster.Addr() != "" {
+		t.Errorf("failed cluster has address %s", cluster.Addr())
+	}
+
+	// so it can be started again
+	if err := cluster.Start(ctx); err != nil {
+		t.Fatalf("second start is %v", err)
+	}
+	if err := cluster.Start(ctx); err == nil || err.Error() != "cluster already started" {
+		t.Errorf("third start is %v", err)
+	}
+	if err := cluster.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.Links) != 0 {
+		t.Errorf("%d links left after stopping", len(fake.Links))
+	}
+}
+
+func TestStopErrors(t *testing.T) {
+	fake := network.NewFake()
+	failed := true
+	cluster := New(testSpec(t, flakyLinks{Fake: fake, failed: &failed, stuck: "nmdeth1"}))
+
+	if err := cluster.Stop(); err == nil || err.Error() != "cluster not started" {
+		t.Errorf("stop before start is %v", err)
+	}
+	if err := cluster.Node("c0").Kill(); err == nil || err.Error() != "cluster not started" {
+		t.Errorf("kill before start is %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := cluster.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// what cannot be cleaned is returned, every time stop is called
+	for i := 0; i < 2; i++ {
+		if err := cluster.Stop(); err == nil || !strings.Contains(err.Error(), "nmdc0") {
+			t.Errorf("stop %d is %v, want an error for nmdc0", i, err)
+		}
+	}
+	if _, ok := fake.Links["nmdeth0"]; ok {
+		t.Error("nmdeth0 was not removed")
+	}
+}
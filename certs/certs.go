@@ -0,0 +1,153 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"time"
+
+	"github.com/mmcquillan/nomad-box/run"
+)
+
+type CA struct {
+	Cert *x509.Certificate
+	Key  crypto.Signer
+}
+
+func LoadOrMakeCA(r run.Runner, certFile string, keyFile string) (ca CA, err error) {
+
+	// reuse a persisted ca
+	if _, err := os.Stat(certFile); err == nil {
+		return loadCA(certFile, keyFile)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return ca, err
+	}
+	serial, err := serialNumber()
+	if err != nil {
+		return ca, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "nomad-box CA", Organization: []string{"nomad-box"}},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return ca, err
+	}
+	if err := writeCert(r, certFile, der); err != nil {
+		return ca, err
+	}
+	if err := writeKey(r, keyFile, key); err != nil {
+		return ca, err
+	}
+	ca.Cert, err = x509.ParseCertificate(der)
+	ca.Key = key
+	return ca, err
+}
+
+func MakeCert(r run.Runner, ca CA, certFile string, keyFile string, name string, dnsNames []string, ips []string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := serialNumber()
+	if err != nil {
+		return err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name, Organization: []string{"nomad-box"}},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     dnsNames,
+	}
+	for _, ip := range ips {
+		if parsed := net.ParseIP(ip); parsed != nil {
+			template.IPAddresses = append(template.IPAddresses, parsed)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Cert, &key.PublicKey, ca.Key)
+	if err != nil {
+		return err
+	}
+	if err := writeCert(r, certFile, der); err != nil {
+		return err
+	}
+	return writeKey(r, keyFile, key)
+}
+
+func GossipKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+func CheckGossipKey(key string) error {
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return err
+	}
+	if len(raw) != 32 {
+		return errors.New("gossip key must be 32 bytes")
+	}
+	return nil
+}
+
+func loadCA(certFile string, keyFile string) (ca CA, err error) {
+	certPem, err := os.ReadFile(certFile)
+	if err != nil {
+		return ca, err
+	}
+	keyPem, err := os.ReadFile(keyFile)
+	if err != nil {
+		return ca, err
+	}
+	certBlock, _ := pem.Decode(certPem)
+	keyBlock, _ := pem.Decode(keyPem)
+	if certBlock == nil || keyBlock == nil {
+		return ca, errors.New("cannot decode ca " + certFile)
+	}
+	ca.Cert, err = x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return ca, err
+	}
+	ca.Key, err = x509.ParseECPrivateKey(keyBlock.Bytes)
+	return ca, err
+}
+
+func writeCert(r run.Runner, file string, der []byte) error {
+	return run.Or(r).WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+func writeKey(r run.Runner, file string, key *ecdsa.PrivateKey) error {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return run.Or(r).WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+}
+
+func serialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
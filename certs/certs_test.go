@@ -0,0 +1,107 @@
+package certs
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/mmcquillan/nomad-box/run"
+)
+
+func parseCert(t *testing.T, recorder *run.Recorder, file string) *x509.Certificate {
+	t.Helper()
+	block, _ := pem.Decode(recorder.Files[file])
+	if block == nil {
+		t.Fatalf("%s is not pem", file)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestMakeCert(t *testing.T) {
+	recorder := &run.Recorder{}
+	dir := t.TempDir()
+	ca, err := LoadOrMakeCA(recorder, dir+"/ca.pem", dir+"/ca-key.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ca.Cert.IsCA {
+		t.Error("ca is not a ca")
+	}
+
+	// a server of region west as the nodes make it
+	dnsNames := []string{"server.west.nomad", "localhost"}
+	ips := []string{"10.10.10.1", "127.0.0.1", "not-an-ip"}
+	if err := MakeCert(recorder, ca, dir+"/nmds0.pem", dir+"/nmds0-key.pem", "nmds0", dnsNames, ips); err != nil {
+		t.Fatal(err)
+	}
+	cert := parseCert(t, recorder, dir+"/nmds0.pem")
+	if cert.Subject.CommonName != "nmds0" {
+		t.Errorf("common name is %s", cert.Subject.CommonName)
+	}
+	for _, name := range dnsNames {
+		if err := cert.VerifyHostname(name); err != nil {
+			t.Error(err)
+		}
+	}
+	if len(cert.IPAddresses) != 2 || !cert.IPAddresses[0].Equal(net.ParseIP("10.10.10.1")) || !cert.IPAddresses[1].Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("ips are %v", cert.IPAddresses)
+	}
+
+	// chains to the ca for both ends of mTLS
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Cert)
+	for _, usage := range []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth} {
+		if _, err := cert.Verify(x509.VerifyOptions{Roots: roots, DNSName: "server.west.nomad", KeyUsages: []x509.ExtKeyUsage{usage}}); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// keys are for the owner only
+	for _, step := range recorder.Steps {
+		if (step.Path == dir+"/ca-key.pem" || step.Path == dir+"/nmds0-key.pem") && step.Mode != "-rw-------" {
+			t.Errorf("%s is written %s", step.Path, step.Mode)
+		}
+	}
+}
+
+func TestLoadOrMakeCAReuses(t *testing.T) {
+	dir := t.TempDir()
+	made, err := LoadOrMakeCA(run.Shell{}, dir+"/ca.pem", dir+"/ca-key.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a persisted ca is read back rather than made again
+	recorder := &run.Recorder{}
+	loaded, err := LoadOrMakeCA(recorder, dir+"/ca.pem", dir+"/ca-key.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Cert.Equal(made.Cert) || len(recorder.Steps) != 0 {
+		t.Errorf("ca was made again: %v", recorder.Commands)
+	}
+	if info, err := os.Stat(dir + "/ca-key.pem"); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("ca key is %v %v", info.Mode(), err)
+	}
+}
+
+func TestGossipKey(t *testing.T) {
+	key, err := GossipKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckGossipKey(key); err != nil {
+		t.Error(err)
+	}
+	for _, bad := range []string{"", "not base64!", "c2hvcnQ="} {
+		if err := CheckGossipKey(bad); err == nil {
+			t.Errorf("%q passed", bad)
+		}
+	}
+}
@@ -1,26 +1,30 @@
 package checks
 
 import (
+	"errors"
+	"net"
 	"os"
 	"os/exec"
 	"os/user"
 	"runtime"
+	"strings"
+	"text/template"
 
+	"github.com/mmcquillan/nomad-box/certs"
 	"github.com/mmcquillan/nomad-box/config"
 	"github.com/mmcquillan/nomad-box/network"
 	"github.com/mmcquillan/nomad-box/run"
 )
 
-func Checks(cfg *config.Config) {
+func Checks(cfg *config.Config) error {
 
 	run.Header("Nomad Box Pre Checks")
 
 	// check we are on a supported OS
 	run.Out("Checking OS")
 	if runtime.GOOS != "linux" {
-		run.Error("nomad-box is made for the linux platform")
-		if !cfg.Plan {
-			os.Exit(2)
+		if err := failed(cfg, "nomad-box is made for the linux platform"); err != nil {
+			return err
 		}
 	}
 
@@ -28,15 +32,13 @@ func Checks(cfg *config.Config) {
 	run.Out("Checking Users")
 	user, err := user.Current()
 	if err != nil {
-		run.Error("Could not read the current user")
-		if !cfg.Plan {
-			os.Exit(2)
+		if err := failed(cfg, "Could not read the current user"); err != nil {
+			return err
 		}
 	}
-	if user.Username != "root" {
-		run.Error("nomad-box must be run with root privledge")
-		if !cfg.Plan {
-			os.Exit(2)
+	if err == nil && user.Username != "root" {
+		if err := failed(cfg, "nomad-box must be run with root privledge"); err != nil {
+			return err
 		}
 	}
 
@@ -44,46 +46,156 @@ func Checks(cfg *config.Config) {
 	run.Out("Checking Installed Tools")
 	_, err = exec.LookPath("ip")
 	if err != nil {
-		run.Error("ip is not installed")
-		if !cfg.Plan {
-			os.Exit(2)
+		if err := failed(cfg, "ip is not installed"); err != nil {
+			return err
+		}
+	}
+
+	// check the network backend
+	run.Out("Checking Network Backend")
+	if cfg.NetBackend != "netlink" && cfg.NetBackend != "ip" {
+		if err := failed(cfg, "Unknown network backend "+cfg.NetBackend+" (netlink, ip)"); err != nil {
+			return err
+		}
+	}
+
+	// check tc for shaped groups
+	for _, g := range cfg.Groups {
+		if g.Netem == (config.Netem{}) {
+			continue
+		}
+		run.Out("Checking Netem")
+		if _, err = exec.LookPath("tc"); err != nil {
+			if err := failed(cfg, "tc is not installed"); err != nil {
+				return err
+			}
+		}
+		if _, err = exec.LookPath("ping"); err != nil && g.Netem.Delay != "" {
+			if err := failed(cfg, "ping is not installed, it checks the delay"); err != nil {
+				return err
+			}
+		}
+		if g.Netem.Jitter != "" && g.Netem.Delay == "" {
+			if err := failed(cfg, "Netem jitter needs a delay"); err != nil {
+				return err
+			}
+		}
+		if !cfg.Netns {
+			if err := failed(cfg, "Netem needs -netns, nodes without a namespace talk over lo"); err != nil {
+				return err
+			}
+		}
+	}
+
+	// check restart policies
+	run.Out("Checking Restart Policies")
+	for _, g := range cfg.Groups {
+		policy := g.Restart.Policy
+		if policy == "" {
+			policy = cfg.Restart.Policy
+		}
+		switch policy {
+		case "never":
+		case "on-failure", "always":
+			if cfg.Detach {
+				run.Warn("Restart policy " + policy + " is not supervised when detached")
+			}
+		default:
+			if err := failed(cfg, "Unknown restart policy "+policy); err != nil {
+				return err
+			}
 		}
 	}
 
 	// check for even server number
 	run.Out("Checking Server Count")
-	if cfg.Servers%2 == 0 {
-		run.Warn("Even number of Servers is weird")
+	servers := make(map[string]int)
+	clients := make(map[string]int)
+	for _, g := range cfg.Groups {
+		if g.Server {
+			servers[g.Region] += g.Count
+		} else {
+			clients[g.Region] += g.Count
+		}
+	}
+	for region, count := range servers {
+		if count > 0 && count%2 == 0 {
+			run.Warn("Even number of Servers is weird in region " + region)
+		}
+	}
+
+	// check every region with clients has servers
+	run.Out("Checking Region Topology")
+	for region, count := range clients {
+		if count > 0 && servers[region] == 0 {
+			if err := failed(cfg, "No Servers for Clients in region "+region); err != nil {
+				return err
+			}
+		}
 	}
 
 	// check binary exists
 	run.Out("Checking Nomad Binary location")
-	if _, err := os.Stat(cfg.Binary); err != nil {
-		run.Error("Nomad Binary does not exist")
-		if !cfg.Plan {
-			os.Exit(2)
+	binaries := groupValues(cfg, cfg.Binary, cfg.Binary, func(g config.Group) string { return g.Binary })
+	for _, binary := range cfg.Binaries {
+		binaries = append(binaries, binary)
+	}
+	cfg.Versions = make(map[string]string)
+	for _, binary := range binaries {
+		if _, ok := cfg.Versions[binary]; ok {
+			continue
+		}
+		if _, err := os.Stat(binary); err != nil {
+			if err := failed(cfg, "Nomad Binary does not exist: "+binary); err != nil {
+				return err
+			}
+			continue
 		}
+
+		// report the version of every distinct binary
+		cfg.Versions[binary] = BinaryVersion(cfg.Runner, binary)
+		run.Out("Nomad Binary " + binary + " is " + cfg.Versions[binary])
 	}
 
-	// check server config
-	if cfg.ServerConfig != "" {
-		run.Out("Checking Server Config")
-		if _, err := os.Stat(cfg.ServerConfig); err != nil {
-			run.Error("Server Config does not exist")
-			if !cfg.Plan {
-				os.Exit(2)
+	// check node configs
+	run.Out("Checking Node Configs")
+	for _, path := range groupValues(cfg, cfg.ServerConfig, cfg.ClientConfig, func(g config.Group) string { return g.Config }) {
+		if _, err := os.Stat(path); err != nil {
+			if err := failed(cfg, "Node Config does not exist: "+path); err != nil {
+				return err
+			}
+			continue
+		}
+		files, err := config.TemplateFiles(path)
+		if err != nil {
+			if err := failed(cfg, "Node Config has nothing to render: "+err.Error()); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, file := range files {
+			if _, err := template.ParseFiles(file); err != nil {
+				if err := failed(cfg, "Node Config is not a valid template: "+file+": "+err.Error()); err != nil {
+					return err
+				}
 			}
 		}
 	}
 
-	// check client config
-	if cfg.ClientConfig != "" {
-		run.Out("Checking Client Config")
-		if _, err := os.Stat(cfg.ClientConfig); err != nil {
-			run.Error("Client Config does not exist")
-			if !cfg.Plan {
-				os.Exit(2)
+	// check client settings
+	run.Out("Checking Client Settings")
+	for _, g := range cfg.Groups {
+		if g.Server && (len(g.Meta) > 0 || g.NodeClass != "" || len(g.HostVolumes) > 0) {
+			run.Warn("Meta, node class and host volumes are ignored on server groups")
+		}
+		volumes := make(map[string]bool)
+		for _, v := range g.HostVolumes {
+			if v.Name == "" || strings.ContainsAny(v.Name, "/ ") || volumes[v.Name] {
+				if err := failed(cfg, "Host volume names must be unique and not empty or contain / or spaces: "+v.Name); err != nil {
+					return err
+				}
 			}
+			volumes[v.Name] = true
 		}
 	}
 
@@ -91,19 +203,80 @@ func Checks(cfg *config.Config) {
 	run.Out("Checking Cidr Formatting")
 	cfg.Ips, err = network.CidrToIps(cfg.Cidr)
 	if err != nil {
-		run.Error("Cidr formatting")
-		run.Error(err.Error())
-		if !cfg.Plan {
-			os.Exit(2)
+		if err := failed(cfg, "Cidr formatting"+": "+err.Error()); err != nil {
+			return err
 		}
 	}
 
-	// check cidr vs server count
+	// check cidr vs server count, namespaces also need a bridge address
 	run.Out("Checking Cidr / Server Count")
-	if len(cfg.Ips) < cfg.Servers+cfg.Clients {
-		run.Error("Cidr does not allow enough IP's")
-		if !cfg.Plan {
-			os.Exit(2)
+	needed := cfg.Servers + cfg.Clients
+	if cfg.Netns {
+		needed++
+	}
+	if len(cfg.Ips) < needed {
+		if err := failed(cfg, "Cidr does not allow enough IP's"); err != nil {
+			return err
+		}
+	}
+
+	// check acls
+	if cfg.ACL {
+		run.Out("Checking ACLs")
+		regions := make(map[string]bool)
+		for _, g := range cfg.Groups {
+			regions[g.Region] = true
+		}
+		if len(regions) > 1 {
+			if err := failed(cfg, "ACLs are only bootstrapped for a single region"); err != nil {
+				return err
+			}
+		}
+		if cfg.ACLPolicies != "" {
+			if _, err := os.Stat(cfg.ACLPolicies); err != nil {
+				if err := failed(cfg, "ACL Policies directory does not exist"); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// check gossip key, making one when none is given
+	if cfg.GossipEncrypt {
+		run.Out("Checking Gossip Key")
+		if cfg.GossipKey == "" {
+			cfg.GossipKey, err = certs.GossipKey()
+		} else {
+			err = certs.CheckGossipKey(cfg.GossipKey)
+		}
+		if err != nil {
+			if err := failed(cfg, "Gossip Key"+": "+err.Error()); err != nil {
+				return err
+			}
+		}
+	}
+
+	// check control api address
+	if cfg.Control != "" {
+		run.Out("Checking Control API")
+		if cfg.Detach {
+			run.Warn("Control API only runs while the cluster is attached")
+		}
+		if !strings.HasPrefix(cfg.Control, "unix:") {
+			host, _, err := net.SplitHostPort(cfg.Control)
+			ip := net.ParseIP(host)
+			if err != nil || (host != "localhost" && (ip == nil || !ip.IsLoopback())) {
+				if err := failed(cfg, "Control API must be a localhost address or unix:<path>: "+cfg.Control); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// check namespaces
+	if cfg.Netns && cfg.BindServer != "" {
+		if err := failed(cfg, "Bind Server cannot be used with network namespaces"); err != nil {
+			return err
 		}
 	}
 
@@ -112,13 +285,46 @@ func Checks(cfg *config.Config) {
 		run.Out("Checking Bind Server")
 		ip := network.GetIpFromDevice(cfg.BindServer)
 		if ip == "" {
-			run.Error("Could not find device for Bind Server")
-			if !cfg.Plan {
-				os.Exit(2)
+			if err := failed(cfg, "Could not find device for Bind Server"); err != nil {
+				return err
 			}
 		} else {
 			cfg.Ips[0] = ip
 		}
 	}
 
+	return nil
+}
+
+// failed reports a failed check, plan mode carries on to show them all
+func failed(cfg *config.Config, msg string) error {
+	run.Error(msg)
+	if cfg.Plan {
+		return nil
+	}
+	return errors.New(msg)
+}
+
+func groupValues(cfg *config.Config, server string, client string, value func(config.Group) string) (values []string) {
+	seen := make(map[string]bool)
+	for _, g := range cfg.Groups {
+		v := value(g)
+		if v == "" && g.Server {
+			v = server
+		}
+		if v == "" && !g.Server {
+			v = client
+		}
+		if v != "" && !seen[v] {
+			seen[v] = true
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func BinaryVersion(r run.Runner, binary string) string {
+	out, _ := run.Or(r).Output(binary + " version")
+	line, _, _ := strings.Cut(out, "\n")
+	return strings.TrimSpace(strings.TrimPrefix(line, "Nomad "))
 }
@@ -0,0 +1,73 @@
+package checks
+
+import (
+	"io"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/mmcquillan/nomad-box/config"
+	"github.com/mmcquillan/nomad-box/run"
+)
+
+func TestMain(m *testing.M) {
+	run.Output = io.Discard
+	os.Exit(m.Run())
+}
+
+func TestBinaryVersion(t *testing.T) {
+	cases := []struct {
+		output string
+		want   string
+	}{
+		{output: "Nomad v1.7.2\nBuildDate 2023-12-13T19:59:42Z\nRevision 64e3dca\n", want: "v1.7.2"},
+		{output: "Nomad v1.8.0-beta.1 (6f1a1bf)\n", want: "v1.8.0-beta.1 (6f1a1bf)"},
+		{output: "command not found", want: "command not found"},
+		{output: "", want: ""},
+	}
+	for _, c := range cases {
+		recorder := &run.Recorder{Outputs: map[string]string{"/bin/nomad version": c.output}}
+		if got := BinaryVersion(recorder, "/bin/nomad"); got != c.want {
+			t.Errorf("%q is version %q, want %q", c.output, got, c.want)
+		}
+	}
+}
+
+func TestChecksVersions(t *testing.T) {
+	dir := t.TempDir()
+	binaries := map[string]string{"old": dir + "/nomad-old", "new": dir + "/nomad-new", "next": dir + "/nomad-next"}
+	for _, binary := range binaries {
+		if err := os.WriteFile(binary, nil, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recorder := &run.Recorder{Outputs: map[string]string{
+		binaries["old"] + " version":  "Nomad v1.6.0\n",
+		binaries["new"] + " version":  "Nomad v1.7.0\n",
+		binaries["next"] + " version": "Nomad v1.8.0\n",
+	}}
+	cfg := config.Defaults()
+	cfg.Servers = 1
+	cfg.Clients = 2
+	cfg.Binary = binaries["old"]
+	cfg.Binaries = map[string]string{"c1": binaries["next"]}
+	cfg.Directory = dir
+	cfg.Plan = true
+	cfg.Runner = recorder
+	if err := config.Resolve(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Groups[1].Binary = binaries["new"]
+
+	// every distinct binary, from the flag, a group or a node, is asked once
+	if err := Checks(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{binaries["old"]: "v1.6.0", binaries["new"]: "v1.7.0", binaries["next"]: "v1.8.0"}
+	if !reflect.DeepEqual(cfg.Versions, want) {
+		t.Errorf("versions are %v, want %v", cfg.Versions, want)
+	}
+	if len(recorder.Commands) != len(want) {
+		t.Errorf("ran %v", recorder.Commands)
+	}
+}
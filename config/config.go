@@ -1,48 +1,142 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/mmcquillan/nomad-box/network"
 	"github.com/mmcquillan/nomad-box/run"
+	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	Servers      int
-	Clients      int
-	Binary       string
-	Directory    string
-	Cidr         string
-	BindServer   string
-	Log          bool
-	LogLevel     string
-	Prefix       string
-	ServerPrefix string
-	ClientPrefix string
-	ServerConfig string
-	ClientConfig string
-	ServerParams string
-	ClientParams string
-	Export       bool `json:"-"`
-	Import       bool `json:"-"`
-	Persist      bool
-	Plan         bool
-	Clean        bool
-	UI           bool
-	Ips          []string `json:"-"`
-}
-
-func MakeConfig() (cfg Config) {
+	Command       string            `json:"-" yaml:"-"`
+	Args          []string          `json:"-" yaml:"-"`
+	Detach        bool              `json:"-" yaml:"-"`
+	Between       string            `json:"-" yaml:"-"`
+	And           string            `json:"-" yaml:"-"`
+	Netem         Netem             `json:"-" yaml:"-"`
+	Chaos         Chaos             `json:"-" yaml:"-"`
+	Order         string            `json:"-" yaml:"-"`
+	Deadline      time.Duration     `json:"-" yaml:"-"`
+	Wait          bool              `json:"-" yaml:"-"`
+	Spec          string            `json:"-" yaml:"-"`
+	Servers       int               `json:"servers" yaml:"servers"`
+	Clients       int               `json:"clients" yaml:"clients"`
+	Topology      string            `json:"topology" yaml:"topology"`
+	Groups        []Group           `json:"groups" yaml:"groups"`
+	Binary        string            `json:"binary" yaml:"binary"`
+	Binaries      map[string]string `json:"binaries,omitempty" yaml:"binaries,omitempty"`
+	Directory     string            `json:"directory" yaml:"directory"`
+	Cidr          string            `json:"cidr" yaml:"cidr"`
+	BindServer    string            `json:"bind_server" yaml:"bind_server"`
+	Netns         bool              `json:"netns" yaml:"netns"`
+	NetBackend    string            `json:"net_backend" yaml:"net_backend"`
+	Log           bool              `json:"log" yaml:"log"`
+	LogLevel      string            `json:"log_level" yaml:"log_level"`
+	Prefix        string            `json:"prefix" yaml:"prefix"`
+	ServerPrefix  string            `json:"server_prefix" yaml:"server_prefix"`
+	ClientPrefix  string            `json:"client_prefix" yaml:"client_prefix"`
+	ServerConfig  string            `json:"server_config" yaml:"server_config"`
+	ClientConfig  string            `json:"client_config" yaml:"client_config"`
+	ServerParams  string            `json:"server_params" yaml:"server_params"`
+	ClientParams  string            `json:"client_params" yaml:"client_params"`
+	Export        bool              `json:"-" yaml:"-"`
+	Import        bool              `json:"-" yaml:"-"`
+	Persist       bool              `json:"persist" yaml:"persist"`
+	ReadyTimeout  time.Duration     `json:"ready_timeout" yaml:"ready_timeout"`
+	Restart       Restart           `json:"restart" yaml:"restart"`
+	Plan          bool              `json:"-" yaml:"-"`
+	Format        string            `json:"-" yaml:"-"`
+	Clean         bool              `json:"-" yaml:"-"`
+	UI            bool              `json:"ui" yaml:"ui"`
+	TLS           bool              `json:"tls" yaml:"tls"`
+	ACL           bool              `json:"acl" yaml:"acl"`
+	ACLPolicies   string            `json:"acl_policies" yaml:"acl_policies"`
+	GossipEncrypt bool              `json:"gossip_encrypt" yaml:"gossip_encrypt"`
+	GossipKey     string            `json:"gossip_key,omitempty" yaml:"gossip_key,omitempty"`
+	Control       string            `json:"control,omitempty" yaml:"control,omitempty"`
+	Ips           []string          `json:"-" yaml:"-"`
+	Versions      map[string]string `json:"-" yaml:"-"`
+	Runner        run.Runner        `json:"-" yaml:"-"`
+	Links         network.Backend   `json:"-" yaml:"-"`
+}
 
-	// defaults
+type Group struct {
+	Name        string            `json:"name,omitempty" yaml:"name,omitempty"`
+	Region      string            `json:"region" yaml:"region"`
+	Dc          string            `json:"dc" yaml:"dc"`
+	Pool        string            `json:"pool" yaml:"pool"`
+	Server      bool              `json:"server" yaml:"server"`
+	Count       int               `json:"count" yaml:"count"`
+	Binary      string            `json:"binary,omitempty" yaml:"binary,omitempty"`
+	Config      string            `json:"config,omitempty" yaml:"config,omitempty"`
+	Params      string            `json:"params,omitempty" yaml:"params,omitempty"`
+	Meta        map[string]string `json:"meta,omitempty" yaml:"meta,omitempty"`
+	NodeClass   string            `json:"node_class,omitempty" yaml:"node_class,omitempty"`
+	HostVolumes []HostVolume      `json:"host_volumes,omitempty" yaml:"host_volumes,omitempty"`
+	Netem       Netem             `json:"netem,omitempty" yaml:"netem,omitempty"`
+	Restart     Restart           `json:"restart,omitempty" yaml:"restart,omitempty"`
+}
+
+type HostVolume struct {
+	Name     string `json:"name" yaml:"name"`
+	ReadOnly bool   `json:"read_only,omitempty" yaml:"read_only,omitempty"`
+}
+
+type Chaos struct {
+	Seed     int64
+	Interval time.Duration
+	Duration time.Duration
+	Pause    time.Duration
+	Actions  string
+	Targets  string
+	Quorum   bool
+}
+
+type Restart struct {
+	Policy  string        `json:"policy,omitempty" yaml:"policy,omitempty"`
+	Max     int           `json:"max,omitempty" yaml:"max,omitempty"`
+	Backoff time.Duration `json:"backoff,omitempty" yaml:"backoff,omitempty"`
+}
+
+type Netem struct {
+	Delay  string `json:"delay,omitempty" yaml:"delay,omitempty"`
+	Jitter string `json:"jitter,omitempty" yaml:"jitter,omitempty"`
+	Loss   string `json:"loss,omitempty" yaml:"loss,omitempty"`
+}
+
+// Defaults is the config before env vars, a spec and flags are applied
+func Defaults() (cfg Config) {
+	cfg.Command = "up"
+	cfg.Detach = false
+	cfg.Between = ""
+	cfg.And = ""
+	cfg.Netem = Netem{}
+	cfg.Order = "servers"
+	cfg.Deadline = time.Hour
+	cfg.Wait = false
+	cfg.Chaos = Chaos{Seed: 0, Interval: 30 * time.Second, Duration: 0, Pause: 10 * time.Second, Actions: "kill,pause,restart", Targets: "all", Quorum: true}
+	cfg.Spec = ""
 	cfg.Servers = 3
 	cfg.Clients = 6
+	cfg.Topology = ""
 	cfg.Binary = "/usr/bin/nomad"
 	cfg.Directory = "/tmp/nomad-box"
 	cfg.Cidr = "10.10.10.0/24"
 	cfg.BindServer = ""
+	cfg.Netns = false
+	cfg.NetBackend = "netlink"
 	cfg.Log = false
 	cfg.LogLevel = "INFO"
 	cfg.Prefix = "nmd"
@@ -55,17 +149,40 @@ func MakeConfig() (cfg Config) {
 	cfg.Export = false
 	cfg.Import = false
 	cfg.Persist = false
+	cfg.ReadyTimeout = 2 * time.Minute
+	cfg.Restart = Restart{Policy: "never", Max: 0, Backoff: 5 * time.Second}
 	cfg.Plan = false
+	cfg.Format = "text"
 	cfg.Clean = false
 	cfg.UI = false
+	cfg.TLS = false
+	cfg.ACL = false
+	cfg.ACLPolicies = ""
+	cfg.GossipEncrypt = false
+	cfg.GossipKey = ""
+	cfg.Control = ""
+	return cfg
+}
+
+// MakeConfig is the config of the command line, the caller decides how to exit on an error
+func MakeConfig() (cfg Config, err error) {
+
+	// defaults
+	cfg = Defaults()
 
 	// env vars
+	if val := os.Getenv("NOMAD_BOX_SPEC"); val != "" {
+		cfg.Spec = val
+	}
 	if val, err := strconv.Atoi(os.Getenv("NOMAD_BOX_SERVERS")); err == nil {
 		cfg.Servers = val
 	}
 	if val, err := strconv.Atoi(os.Getenv("NOMAD_BOX_CLIENTS")); err == nil {
 		cfg.Clients = val
 	}
+	if val := os.Getenv("NOMAD_BOX_TOPOLOGY"); val != "" {
+		cfg.Topology = val
+	}
 	if val := os.Getenv("NOMAD_BOX_BINARY"); val != "" {
 		cfg.Binary = val
 	}
@@ -78,6 +195,12 @@ func MakeConfig() (cfg Config) {
 	if val := os.Getenv("NOMAD_BOX_BIND_SERVER"); val != "" {
 		cfg.BindServer = val
 	}
+	if val, err := strconv.ParseBool(os.Getenv("NOMAD_BOX_NETNS")); err == nil {
+		cfg.Netns = val
+	}
+	if val := os.Getenv("NOMAD_BOX_NET_BACKEND"); val != "" {
+		cfg.NetBackend = val
+	}
 	if val, err := strconv.ParseBool(os.Getenv("NOMAD_BOX_LOG")); err == nil {
 		cfg.Log = val
 	}
@@ -114,23 +237,73 @@ func MakeConfig() (cfg Config) {
 	if val, err := strconv.ParseBool(os.Getenv("NOMAD_BOX_PERSIST")); err == nil {
 		cfg.Persist = val
 	}
+	if val, err := time.ParseDuration(os.Getenv("NOMAD_BOX_READY_TIMEOUT")); err == nil {
+		cfg.ReadyTimeout = val
+	}
+	if val := os.Getenv("NOMAD_BOX_RESTART"); val != "" {
+		cfg.Restart.Policy = val
+	}
+	if val, err := strconv.Atoi(os.Getenv("NOMAD_BOX_RESTART_MAX")); err == nil {
+		cfg.Restart.Max = val
+	}
+	if val, err := time.ParseDuration(os.Getenv("NOMAD_BOX_RESTART_BACKOFF")); err == nil {
+		cfg.Restart.Backoff = val
+	}
 	if val, err := strconv.ParseBool(os.Getenv("NOMAD_BOX_PLAN")); err == nil {
 		cfg.Plan = val
 	}
+	if val := os.Getenv("NOMAD_BOX_FORMAT"); val != "" {
+		cfg.Format = val
+	}
 	if val, err := strconv.ParseBool(os.Getenv("NOMAD_BOX_CLEAN")); err == nil {
 		cfg.Clean = val
 	}
 	if val, err := strconv.ParseBool(os.Getenv("NOMAD_BOX_UI")); err == nil {
 		cfg.UI = val
 	}
+	if val, err := strconv.ParseBool(os.Getenv("NOMAD_BOX_TLS")); err == nil {
+		cfg.TLS = val
+	}
+	if val, err := strconv.ParseBool(os.Getenv("NOMAD_BOX_ACL")); err == nil {
+		cfg.ACL = val
+	}
+	if val := os.Getenv("NOMAD_BOX_ACL_POLICIES"); val != "" {
+		cfg.ACLPolicies = val
+	}
+	if val, err := strconv.ParseBool(os.Getenv("NOMAD_BOX_GOSSIP_ENCRYPT")); err == nil {
+		cfg.GossipEncrypt = val
+	}
+	if val := os.Getenv("NOMAD_BOX_CONTROL"); val != "" {
+		cfg.Control = val
+	}
 
 	// flags
+	flag.BoolVar(&cfg.Detach, "d", cfg.Detach, "Detach and leave the cluster running")
+	flag.StringVar(&cfg.Between, "between", cfg.Between, "Nodes on one side of a partition (s0,s1)")
+	flag.StringVar(&cfg.And, "and", cfg.And, "Nodes on the other side of a partition (s2,c0-c3)")
+	flag.StringVar(&cfg.Netem.Delay, "delay", cfg.Netem.Delay, "Netem delay for a node (80ms)")
+	flag.StringVar(&cfg.Netem.Jitter, "jitter", cfg.Netem.Jitter, "Netem jitter for a node (10ms)")
+	flag.StringVar(&cfg.Netem.Loss, "loss", cfg.Netem.Loss, "Netem packet loss for a node (1%)")
+	flag.Int64Var(&cfg.Chaos.Seed, "seed", cfg.Chaos.Seed, "Chaos seed to replay a schedule (0 for random)")
+	flag.DurationVar(&cfg.Chaos.Interval, "interval", cfg.Chaos.Interval, "Time between chaos actions")
+	flag.DurationVar(&cfg.Chaos.Duration, "duration", cfg.Chaos.Duration, "How long chaos runs (0 until interrupted)")
+	flag.DurationVar(&cfg.Chaos.Pause, "pause", cfg.Chaos.Pause, "How long a chaos pause stops an agent")
+	flag.StringVar(&cfg.Chaos.Actions, "actions", cfg.Chaos.Actions, "Chaos actions (kill,pause,restart)")
+	flag.StringVar(&cfg.Chaos.Targets, "targets", cfg.Chaos.Targets, "Chaos targets (all, servers, clients)")
+	flag.BoolVar(&cfg.Chaos.Quorum, "quorum", cfg.Chaos.Quorum, "Chaos never drops servers below quorum")
+	flag.StringVar(&cfg.Order, "order", cfg.Order, "Upgrade servers or clients first")
+	flag.DurationVar(&cfg.Deadline, "deadline", cfg.Deadline, "Drain deadline before allocations are stopped")
+	flag.BoolVar(&cfg.Wait, "wait", cfg.Wait, "Wait for a drain to complete")
+	flag.StringVar(&cfg.Spec, "spec", cfg.Spec, "Path to a Cluster Spec (json or yaml)")
 	flag.IntVar(&cfg.Servers, "servers", cfg.Servers, "Number of Servers")
 	flag.IntVar(&cfg.Clients, "clients", cfg.Clients, "Number of Clients")
+	flag.StringVar(&cfg.Topology, "topology", cfg.Topology, "Topology as region:dc:servers|clients:count[:pool],...")
 	flag.StringVar(&cfg.Binary, "binary", cfg.Binary, "Location of Nomad Binary")
 	flag.StringVar(&cfg.Directory, "directory", cfg.Directory, "Working Directory")
 	flag.StringVar(&cfg.Cidr, "cidr", cfg.Cidr, "CIDR Block for IP Assignment")
 	flag.StringVar(&cfg.BindServer, "bind-server", cfg.BindServer, "Network device or IP to bind the first server to")
+	flag.BoolVar(&cfg.Netns, "netns", cfg.Netns, "Isolate each node in its own network namespace")
+	flag.StringVar(&cfg.NetBackend, "net-backend", cfg.NetBackend, "Make node devices with netlink or the ip command")
 	flag.BoolVar(&cfg.Log, "log", cfg.Log, "Show Nomad Logs in the console")
 	flag.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "Prefix of Nomad Cluster Members")
 	flag.StringVar(&cfg.Prefix, "prefix", cfg.Prefix, "Prefix of Nomad Cluster Members")
@@ -140,17 +313,74 @@ func MakeConfig() (cfg Config) {
 	flag.StringVar(&cfg.ClientConfig, "client-config", cfg.ClientConfig, "Path to a Client Config")
 	flag.StringVar(&cfg.ServerParams, "server-params", cfg.ServerParams, "Path to a Server Params")
 	flag.StringVar(&cfg.ClientParams, "client-params", cfg.ClientParams, "Path to a Client Params")
-	flag.BoolVar(&cfg.Export, "export", cfg.Export, "Export Nomad Node Layout")
-	flag.BoolVar(&cfg.Import, "import", cfg.Import, "Import Nomad Node Layout")
+	flag.BoolVar(&cfg.Export, "export", cfg.Export, "Export Cluster Spec to config.json")
+	flag.BoolVar(&cfg.Import, "import", cfg.Import, "Import Cluster Spec from config.json")
 	flag.BoolVar(&cfg.Persist, "persist", cfg.Persist, "Persist resources after run")
+	flag.DurationVar(&cfg.ReadyTimeout, "ready-timeout", cfg.ReadyTimeout, "Time to wait for the cluster to be ready (0 to skip)")
+	flag.StringVar(&cfg.Restart.Policy, "restart", cfg.Restart.Policy, "Agent restart policy (never, on-failure, always)")
+	flag.IntVar(&cfg.Restart.Max, "restart-max", cfg.Restart.Max, "Maximum agent restarts (0 for no limit)")
+	flag.DurationVar(&cfg.Restart.Backoff, "restart-backoff", cfg.Restart.Backoff, "Initial backoff between agent restarts")
 	flag.BoolVar(&cfg.Plan, "plan", cfg.Plan, "Plan mode stages but does not run")
+	flag.StringVar(&cfg.Format, "o", cfg.Format, "Plan output format (text, json)")
 	flag.BoolVar(&cfg.Clean, "clean", cfg.Clean, "Clean mode to fix up any residual resources")
 	flag.BoolVar(&cfg.UI, "ui", cfg.UI, "Adds a UI label")
-	flag.Parse()
+	flag.BoolVar(&cfg.TLS, "tls", cfg.TLS, "Generate a CA and run the cluster with mTLS")
+	flag.BoolVar(&cfg.ACL, "acl", cfg.ACL, "Enable and bootstrap ACLs")
+	flag.BoolVar(&cfg.GossipEncrypt, "gossip-encrypt", cfg.GossipEncrypt, "Encrypt server gossip with a generated key")
+	flag.StringVar(&cfg.Control, "control", cfg.Control, "Serve the control API on a localhost address or unix:<path>")
+	flag.StringVar(&cfg.ACLPolicies, "acl-policies", cfg.ACLPolicies, "Directory of ACL policy files to apply with a token each")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: nomad-box [command] [flags]\n\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "Commands:\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  up         Start the cluster (default, -d to detach)\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  status     Show the state of a running cluster\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  down       Stop and clean a running cluster\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  partition  Drop traffic -between nodes -and other nodes\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  heal       Remove all partitions\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  netem      Shape nodes with -delay, -jitter and -loss (none clears)\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  chaos      Kill, pause and restart random agents on a schedule\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  upgrade    Roll the cluster node by node onto -binary\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  keyring    List or rotate the gossip encryption key (list, rotate)\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  scale      Grow or shrink a running cluster to -clients\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  add-node   Add a client to a running cluster (optional group name)\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  remove-node  Drain, stop and clean a client of a running cluster\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  drain      Drain a client (-deadline, -wait, off cancels)\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  eligibility  Set a client's scheduling eligibility (on, off)\n\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "Flags:\n")
+		flag.PrintDefaults()
+	}
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cfg.Command = args[0]
+		args = args[1:]
+	}
+	// positional args can sit between flags
+	for {
+		flag.CommandLine.Parse(args)
+		args = flag.Args()
+		if len(args) == 0 {
+			break
+		}
+		cfg.Args = append(cfg.Args, args[0])
+		args = args[1:]
+	}
+
+	// import config is the spec in the working directory
+	if cfg.Import && cfg.Spec == "" {
+		mydir, _ := os.Getwd()
+		cfg.Spec = mydir + "/config.json"
+	}
 
-	// import config
-	if cfg.Import {
-		cfg = importConfig()
+	// spec file takes precedence over env vars, flags given on the command line over the spec
+	if cfg.Spec != "" {
+		if err := loadSpec(&cfg, flag.CommandLine); err != nil {
+			return cfg, errors.New("cannot load spec: " + err.Error())
+		}
+	}
+
+	// node groups
+	if err := Resolve(&cfg); err != nil {
+		return cfg, errors.New("cannot parse topology: " + err.Error())
 	}
 
 	// export config
@@ -159,23 +389,210 @@ func MakeConfig() (cfg Config) {
 	}
 
 	// return the config
-	return cfg
+	return cfg, nil
 
 }
 
-func importConfig() (cfg Config) {
-	mydir, _ := os.Getwd()
-	file, err := os.ReadFile(mydir + "/config.json")
+// Resolve fills the node groups from the topology and counts the servers and clients
+func Resolve(cfg *Config) error {
+
+	// node groups
+	if len(cfg.Groups) == 0 {
+		groups, err := parseTopology(*cfg)
+		if err != nil {
+			return err
+		}
+		cfg.Groups = groups
+	}
+	cfg.Servers, cfg.Clients = 0, 0
+	for i, g := range cfg.Groups {
+		if g.Region == "" {
+			cfg.Groups[i].Region = "global"
+		}
+		if g.Dc == "" {
+			cfg.Groups[i].Dc = "dc1"
+		}
+		if g.Pool == "" {
+			cfg.Groups[i].Pool = "default"
+		}
+		if g.Server {
+			cfg.Servers += g.Count
+		} else {
+			cfg.Clients += g.Count
+		}
+	}
+
+	return nil
+}
+
+func parseTopology(cfg Config) (groups []Group, err error) {
+
+	// default single region and datacenter
+	if cfg.Topology == "" {
+		groups = append(groups, Group{Region: "global", Dc: "dc1", Pool: "default", Server: true, Count: cfg.Servers})
+		groups = append(groups, Group{Region: "global", Dc: "dc1", Pool: "default", Server: false, Count: cfg.Clients})
+		return groups, nil
+	}
+
+	// region:dc:servers|clients:count[:pool]
+	for _, entry := range strings.Split(cfg.Topology, ",") {
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) < 4 || len(parts) > 5 {
+			return groups, errors.New("invalid topology entry " + entry)
+		}
+		g := Group{Region: parts[0], Dc: parts[1], Pool: "default"}
+		switch parts[2] {
+		case "servers", "server", "s":
+			g.Server = true
+		case "clients", "client", "c":
+			g.Server = false
+		default:
+			return groups, errors.New("invalid node type in topology entry " + entry)
+		}
+		g.Count, err = strconv.Atoi(parts[3])
+		if err != nil || g.Count < 0 {
+			return groups, errors.New("invalid count in topology entry " + entry)
+		}
+		if len(parts) == 5 {
+			if g.Server {
+				return groups, errors.New("pool is only valid for clients in topology entry " + entry)
+			}
+			g.Pool = parts[4]
+		}
+		if g.Region == "" || g.Dc == "" || g.Pool == "" {
+			return groups, errors.New("empty field in topology entry " + entry)
+		}
+
+		// a region can span datacenters and pools, the same group twice is a mistake
+		for _, seen := range groups {
+			if seen.Region == g.Region && seen.Dc == g.Dc && seen.Server == g.Server && seen.Pool == g.Pool {
+				return groups, errors.New("duplicate topology entry " + entry)
+			}
+		}
+		groups = append(groups, g)
+	}
+
+	return groups, nil
+}
+
+func loadSpec(cfg *Config, flags *flag.FlagSet) error {
+	given := make(map[string]string)
+	flags.Visit(func(f *flag.Flag) {
+		given[f.Name] = f.Value.String()
+	})
+	if err := LoadSpec(cfg.Spec, cfg); err != nil {
+		return err
+	}
+	for name, value := range given {
+		if err := flags.Set(name, value); err != nil {
+			return err
+		}
+	}
+
+	// groups in the spec decide the counts
+	if len(cfg.Groups) > 0 {
+		for _, name := range []string{"servers", "clients", "topology"} {
+			if _, ok := given[name]; ok {
+				run.Warn("-" + name + " is ignored, the spec has groups")
+			}
+		}
+	}
+	return nil
+}
+
+func LoadSpec(path string, cfg *Config) error {
+	file, err := os.ReadFile(path)
 	if err != nil {
-		run.Error("Cannot Import Config")
-		run.Error(err.Error())
+		return err
 	}
-	err = json.Unmarshal([]byte(file), &cfg)
+	// a stale or mistyped key fails rather than being dropped
+	switch filepath.Ext(path) {
+	case ".json":
+		err = decodeJSON(file, cfg)
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(file))
+		decoder.KnownFields(true)
+		if err = decoder.Decode(cfg); errors.Is(err, io.EOF) {
+			err = nil
+		}
+	default:
+		err = errors.New("unknown spec format " + filepath.Ext(path))
+	}
+	return err
+}
+
+// specDuration reads a duration from a json spec as a string like "1s" or as nanoseconds
+type specDuration time.Duration
+
+func (d *specDuration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		v, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*d = specDuration(v)
+		return nil
+	}
+	var n int64
+	if err := json.Unmarshal(b, &n); err != nil {
+		return errors.New("duration " + string(b) + " is neither a string like \"1s\" nor nanoseconds")
+	}
+	*d = specDuration(n)
+	return nil
+}
+
+func (cfg *Config) UnmarshalJSON(b []byte) error {
+	type plain Config
+	return decodeJSON(b, &struct {
+		*plain
+		ReadyTimeout *specDuration `json:"ready_timeout"`
+	}{
+		plain:        (*plain)(cfg),
+		ReadyTimeout: (*specDuration)(&cfg.ReadyTimeout),
+	})
+}
+
+func (r *Restart) UnmarshalJSON(b []byte) error {
+	type plain Restart
+	return decodeJSON(b, &struct {
+		*plain
+		Backoff *specDuration `json:"backoff"`
+	}{
+		plain:   (*plain)(r),
+		Backoff: (*specDuration)(&r.Backoff),
+	})
+}
+
+// decodeJSON refuses keys the spec does not have, such as the field names exported by older versions
+func decodeJSON(b []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+// TemplateFiles is the node config at path, or the .hcl and .json files nomad would load from a directory
+func TemplateFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
 	if err != nil {
-		run.Error("Cannot Import Config")
-		run.Error(err.Error())
+		return nil, err
 	}
-	return cfg
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	var files []string
+	for _, ext := range []string{"*.hcl", "*.json"} {
+		matches, err := filepath.Glob(filepath.Join(path, ext))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	if len(files) == 0 {
+		return nil, errors.New("no .hcl or .json files in " + path)
+	}
+	sort.Strings(files)
+	return files, nil
 }
 
 func exportConfig(cfg Config) {
@@ -185,7 +602,12 @@ func exportConfig(cfg Config) {
 		run.Error("Cannot Export Config")
 		run.Error(err.Error())
 	}
-	err = os.WriteFile(mydir+"/config.json", cfg_json, 0644)
+	// a gossip key given in a spec is exported with it, so only the owner reads it
+	err = os.WriteFile(mydir+"/config.json", cfg_json, 0600)
+	if err == nil {
+		// WriteFile keeps the mode of a config.json exported before, tighten it too
+		err = os.Chmod(mydir+"/config.json", 0600)
+	}
 	if err != nil {
 		run.Error("Cannot Export Config")
 		run.Error(err.Error())
@@ -0,0 +1,231 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mmcquillan/nomad-box/run"
+)
+
+func TestMain(m *testing.M) {
+	run.Output = io.Discard
+	os.Exit(m.Run())
+}
+
+func writeSpec(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadSpecDurations(t *testing.T) {
+	cases := []struct {
+		name    string
+		file    string
+		spec    string
+		ready   time.Duration
+		backoff time.Duration
+		group   time.Duration
+		fails   bool
+	}{
+		{
+			name:    "json strings",
+			file:    "spec.json",
+			spec:    `{"ready_timeout": "30s", "restart": {"backoff": "1s"}, "groups": [{"count": 1, "restart": {"backoff": "2s"}}]}`,
+			ready:   30 * time.Second,
+			backoff: time.Second,
+			group:   2 * time.Second,
+		},
+		{
+			name:    "json nanoseconds",
+			file:    "spec.json",
+			spec:    `{"ready_timeout": 30000000000, "restart": {"backoff": 1000000000}, "groups": [{"count": 1, "restart": {"backoff": 2000000000}}]}`,
+			ready:   30 * time.Second,
+			backoff: time.Second,
+			group:   2 * time.Second,
+		},
+		{
+			name:    "json left out",
+			file:    "spec.json",
+			spec:    `{"restart": {"policy": "always"}, "groups": [{"count": 1}]}`,
+			ready:   2 * time.Minute,
+			backoff: 5 * time.Second,
+		},
+		{
+			name:  "json bad",
+			file:  "spec.json",
+			spec:  `{"ready_timeout": "soon"}`,
+			fails: true,
+		},
+		{
+			name:    "yaml strings",
+			file:    "spec.yaml",
+			spec:    "ready_timeout: 30s\nrestart:\n  backoff: 1s\ngroups:\n  - count: 1\n    restart:\n      backoff: 2s\n",
+			ready:   30 * time.Second,
+			backoff: time.Second,
+			group:   2 * time.Second,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := Defaults()
+			err := LoadSpec(writeSpec(t, c.file, c.spec), &cfg)
+			if c.fails {
+				if err == nil {
+					t.Error("spec loaded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.ReadyTimeout != c.ready {
+				t.Errorf("ready timeout is %s, want %s", cfg.ReadyTimeout, c.ready)
+			}
+			if cfg.Restart.Backoff != c.backoff {
+				t.Errorf("backoff is %s, want %s", cfg.Restart.Backoff, c.backoff)
+			}
+			if cfg.Groups[0].Restart.Backoff != c.group {
+				t.Errorf("group backoff is %s, want %s", cfg.Groups[0].Restart.Backoff, c.group)
+			}
+		})
+	}
+}
+
+func TestLoadSpecUnderFlags(t *testing.T) {
+	cfg := Defaults()
+	cfg.Directory = "/tmp/from-env"
+	cfg.Binary = "/opt/from-env"
+	cfg.Spec = writeSpec(t, "spec.yaml", "directory: /tmp/from-spec\nbinary: /opt/from-spec\nlog_level: DEBUG\n")
+
+	flags := flag.NewFlagSet("nomad-box", flag.ContinueOnError)
+	flags.StringVar(&cfg.Directory, "directory", cfg.Directory, "")
+	flags.StringVar(&cfg.Binary, "binary", cfg.Binary, "")
+	flags.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "")
+	if err := flags.Parse([]string{"-binary", "/opt/from-flag"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// flags over the spec over env vars
+	if err := loadSpec(&cfg, flags); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Binary != "/opt/from-flag" {
+		t.Errorf("binary is %s, want the flag", cfg.Binary)
+	}
+	if cfg.Directory != "/tmp/from-spec" {
+		t.Errorf("directory is %s, want the spec", cfg.Directory)
+	}
+	if cfg.LogLevel != "DEBUG" {
+		t.Errorf("log level is %s, want the spec", cfg.LogLevel)
+	}
+}
+
+func TestLoadSpecUnknownKeys(t *testing.T) {
+	cases := []struct {
+		name string
+		file string
+		spec string
+	}{
+		{name: "json legacy", file: "config.json", spec: `{"Servers": 3, "BindServer": "eth0"}`},
+		{name: "json group", file: "spec.json", spec: `{"groups": [{"count": 1, "pools": "gpu"}]}`},
+		{name: "json restart", file: "spec.json", spec: `{"restart": {"polcy": "always"}}`},
+		{name: "yaml typo", file: "spec.yaml", spec: "log_levle: DEBUG\n"},
+		{name: "yaml group", file: "spec.yaml", spec: "groups:\n  - count: 1\n    netem:\n      dealy: 80ms\n"},
+	}
+	for _, c := range cases {
+		cfg := Defaults()
+		if err := LoadSpec(writeSpec(t, c.file, c.spec), &cfg); err == nil {
+			t.Errorf("%s: spec loaded", c.name)
+		}
+	}
+
+	// what export writes loads again
+	cfg := Defaults()
+	cfg.BindServer = "eth0"
+	cfg.Restart.Policy = "always"
+	exported, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := Defaults()
+	if err := LoadSpec(writeSpec(t, "config.json", string(exported)), &loaded); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.BindServer != "eth0" || loaded.Restart.Policy != "always" {
+		t.Errorf("loaded %s and %s", loaded.BindServer, loaded.Restart.Policy)
+	}
+}
+
+func TestParseTopology(t *testing.T) {
+	cases := []struct {
+		topology string
+		groups   []Group
+		err      string
+	}{
+		{
+			topology: "",
+			groups: []Group{
+				{Region: "global", Dc: "dc1", Pool: "default", Server: true, Count: 3},
+				{Region: "global", Dc: "dc1", Pool: "default", Count: 6},
+			},
+		},
+		{
+			topology: "west:dc1:servers:3,west:dc1:clients:2, east:dc2:s:1,east:dc2:c:4:gpu",
+			groups: []Group{
+				{Region: "west", Dc: "dc1", Pool: "default", Server: true, Count: 3},
+				{Region: "west", Dc: "dc1", Pool: "default", Count: 2},
+				{Region: "east", Dc: "dc2", Pool: "default", Server: true, Count: 1},
+				{Region: "east", Dc: "dc2", Pool: "gpu", Count: 4},
+			},
+		},
+		{
+			topology: "west:dc1:c:2,west:dc2:c:2,west:dc1:c:1:gpu",
+			groups: []Group{
+				{Region: "west", Dc: "dc1", Pool: "default", Count: 2},
+				{Region: "west", Dc: "dc2", Pool: "default", Count: 2},
+				{Region: "west", Dc: "dc1", Pool: "gpu", Count: 1},
+			},
+		},
+		{topology: "west:dc1:servers", err: "invalid topology entry"},
+		{topology: "west:dc1:c:1:gpu:extra", err: "invalid topology entry"},
+		{topology: "west:dc1:agents:1", err: "invalid node type"},
+		{topology: "west:dc1:c:two", err: "invalid count"},
+		{topology: "west:dc1:c:", err: "invalid count"},
+		{topology: "west:dc1:c:-1", err: "invalid count"},
+		{topology: "west:dc1:s:1:gpu", err: "pool is only valid for clients"},
+		{topology: ":dc1:s:1", err: "empty field"},
+		{topology: "west::c:1", err: "empty field"},
+		{topology: "west:dc1:c:1:", err: "empty field"},
+		{topology: "west:dc1:s:3,west:dc1:s:2", err: "duplicate topology entry"},
+		{topology: "west:dc1:c:1:gpu,west:dc1:c:2:gpu", err: "duplicate topology entry"},
+	}
+	for _, c := range cases {
+		cfg := Defaults()
+		cfg.Topology = c.topology
+		groups, err := parseTopology(cfg)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%q: error is %v, want %q", c.topology, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", c.topology, err)
+			continue
+		}
+		if !reflect.DeepEqual(groups, c.groups) {
+			t.Errorf("%q: groups are %+v, want %+v", c.topology, groups, c.groups)
+		}
+	}
+}
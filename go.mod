@@ -2,7 +2,11 @@ module github.com/mmcquillan/nomad-box
 
 go 1.20
 
-require github.com/shirou/gopsutil/v3 v3.23.1
+require (
+	github.com/shirou/gopsutil/v3 v3.23.1
+	github.com/vishvananda/netlink v1.3.1
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/go-ole/go-ole v1.2.6 // indirect
@@ -10,6 +14,7 @@ require (
 	github.com/power-devops/perfstat v0.0.0-20210106213030-5aafc221ea8c // indirect
 	github.com/tklauser/go-sysconf v0.3.11 // indirect
 	github.com/tklauser/numcpus v0.6.0 // indirect
+	github.com/vishvananda/netns v0.0.5 // indirect
 	github.com/yusufpapurcu/wmi v1.2.2 // indirect
-	golang.org/x/sys v0.4.0 // indirect
+	golang.org/x/sys v0.10.0 // indirect
 )
@@ -0,0 +1,157 @@
+package network
+
+import (
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/mmcquillan/nomad-box/run"
+)
+
+var (
+	ErrExists   = errors.New("already exists")
+	ErrNotFound = errors.New("not found")
+)
+
+// Backend makes the devices of nodes that are not in a namespace
+type Backend interface {
+	LinkExists(name string) (bool, error)
+	AddrExists(ip string) (bool, error)
+	AddDummy(name string) error
+	SetMac(name string, mac string) error
+	AddAddr(name string, cidr string, label string) error
+	SetUp(name string) error
+	DelAddr(name string, cidr string, label string) error
+	DelLink(name string) error
+}
+
+// LinkError is a failed operation on a device, Err is ErrExists or ErrNotFound when known
+type LinkError struct {
+	Op   string
+	Link string
+	Err  error
+}
+
+func (e *LinkError) Error() string {
+	msg := e.Op
+	if e.Link != "" {
+		msg += " " + e.Link
+	}
+	return msg + ": " + e.Err.Error()
+}
+
+func (e *LinkError) Unwrap() error {
+	return e.Err
+}
+
+// NewBackend is the backend by name, netlink unless ip is asked for, r runs the ip commands
+func NewBackend(name string, r run.Runner) Backend {
+	if name == "ip" {
+		return Ip{Runner: r}
+	}
+	return Netlink{}
+}
+
+// Fake keeps devices in memory so tests can check what a backend was asked to do
+type Fake struct {
+	Links map[string]*FakeLink
+}
+
+type FakeLink struct {
+	Mac   string
+	Addrs []string
+	Up    bool
+}
+
+func NewFake() *Fake {
+	return &Fake{Links: make(map[string]*FakeLink)}
+}
+
+func (f *Fake) LinkExists(name string) (bool, error) {
+	_, ok := f.Links[name]
+	return ok, nil
+}
+
+func (f *Fake) AddrExists(ip string) (bool, error) {
+	for _, link := range f.Links {
+		for _, addr := range link.Addrs {
+			if sameIp(addr, ip) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func (f *Fake) AddDummy(name string) error {
+	if _, ok := f.Links[name]; ok {
+		return &LinkError{Op: "add", Link: name, Err: ErrExists}
+	}
+	f.Links[name] = &FakeLink{}
+	return nil
+}
+
+func (f *Fake) SetMac(name string, mac string) error {
+	link, ok := f.Links[name]
+	if !ok {
+		return &LinkError{Op: "set mac", Link: name, Err: ErrNotFound}
+	}
+	if _, err := net.ParseMAC(mac); err != nil {
+		return &LinkError{Op: "set mac", Link: name, Err: err}
+	}
+	link.Mac = mac
+	return nil
+}
+
+func (f *Fake) AddAddr(name string, cidr string, label string) error {
+	link, ok := f.Links[name]
+	if !ok {
+		return &LinkError{Op: "add addr", Link: name, Err: ErrNotFound}
+	}
+	for _, addr := range link.Addrs {
+		if sameIp(addr, cidr) {
+			return &LinkError{Op: "add addr", Link: name, Err: ErrExists}
+		}
+	}
+	link.Addrs = append(link.Addrs, cidr)
+	return nil
+}
+
+func (f *Fake) SetUp(name string) error {
+	link, ok := f.Links[name]
+	if !ok {
+		return &LinkError{Op: "set up", Link: name, Err: ErrNotFound}
+	}
+	link.Up = true
+	return nil
+}
+
+func (f *Fake) DelAddr(name string, cidr string, label string) error {
+	link, ok := f.Links[name]
+	if !ok {
+		return &LinkError{Op: "del addr", Link: name, Err: ErrNotFound}
+	}
+	for i, addr := range link.Addrs {
+		if sameIp(addr, cidr) {
+			link.Addrs = append(link.Addrs[:i], link.Addrs[i+1:]...)
+			return nil
+		}
+	}
+	return &LinkError{Op: "del addr", Link: name, Err: ErrNotFound}
+}
+
+func (f *Fake) DelLink(name string) error {
+	if _, ok := f.Links[name]; !ok {
+		return &LinkError{Op: "del", Link: name, Err: ErrNotFound}
+	}
+	delete(f.Links, name)
+	return nil
+}
+
+// sameIp compares addresses exactly, with or without a prefix length
+func sameIp(a string, b string) bool {
+	a, _, _ = strings.Cut(a, "/")
+	b, _, _ = strings.Cut(b, "/")
+	ipA := net.ParseIP(a)
+	return ipA != nil && ipA.Equal(net.ParseIP(b))
+}
@@ -0,0 +1,94 @@
+package network
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/mmcquillan/nomad-box/run"
+)
+
+// Ip shells out to the ip command through Runner, a Shell when nil
+type Ip struct {
+	Runner run.Runner
+}
+
+func (i Ip) LinkExists(name string) (bool, error) {
+	out, err := run.Or(i.Runner).Output("ip -o link show")
+	if err != nil {
+		return false, ipError("show", name, err)
+	}
+
+	// 3: nmdeth0: <BROADCAST,NOARP,UP,LOWER_UP> ..., veths add @peer
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		link, _, _ := strings.Cut(strings.TrimSuffix(fields[1], ":"), "@")
+		if link == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (i Ip) AddrExists(ip string) (bool, error) {
+	out, err := run.Or(i.Runner).Output("ip -o addr show")
+	if err != nil {
+		return false, ipError("show addr", "", err)
+	}
+
+	// 2: eth0    inet 10.10.10.1/24 brd 10.10.10.255 scope global eth0
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		for i := 0; i+1 < len(fields); i++ {
+			if (fields[i] == "inet" || fields[i] == "inet6") && sameIp(fields[i+1], ip) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func (i Ip) AddDummy(name string) error {
+	return ipError("add", name, run.Command(i.Runner, "ip link add "+name+" type dummy"))
+}
+
+func (i Ip) SetMac(name string, mac string) error {
+	return ipError("set mac", name, run.Command(i.Runner, "ip link set dev "+name+" address "+mac))
+}
+
+func (i Ip) AddAddr(name string, cidr string, label string) error {
+	return ipError("add addr", name, run.Command(i.Runner, "ip addr add "+cidr+" brd + dev "+name+" label "+label))
+}
+
+func (i Ip) SetUp(name string) error {
+	return ipError("set up", name, run.Command(i.Runner, "ip link set dev "+name+" up"))
+}
+
+func (i Ip) DelAddr(name string, cidr string, label string) error {
+	return ipError("del addr", name, run.Command(i.Runner, "ip addr del "+cidr+" brd + dev "+name+" label "+label))
+}
+
+func (i Ip) DelLink(name string) error {
+	return ipError("del", name, run.Command(i.Runner, "ip link delete "+name+" type dummy"))
+}
+
+// ipError types what ip said on stderr
+func ipError(op string, link string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var cmd *run.CommandError
+	if errors.As(err, &cmd) {
+		switch {
+		case strings.Contains(cmd.Stderr, "File exists"):
+			err = ErrExists
+		case strings.Contains(cmd.Stderr, "does not exist"),
+			strings.Contains(cmd.Stderr, "Cannot find device"),
+			strings.Contains(cmd.Stderr, "Cannot assign requested address"):
+			err = ErrNotFound
+		}
+	}
+	return &LinkError{Op: op, Link: link, Err: err}
+}
@@ -0,0 +1,111 @@
+package network
+
+import (
+	"errors"
+	"net"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+)
+
+// Netlink talks to the kernel directly
+type Netlink struct{}
+
+func (Netlink) LinkExists(name string) (bool, error) {
+	_, err := netlink.LinkByName(name)
+	err = linkError("show", name, err)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (Netlink) AddrExists(ip string) (bool, error) {
+	addrs, err := netlink.AddrList(nil, syscall.AF_UNSPEC)
+	if err != nil {
+		return false, linkError("show addr", "", err)
+	}
+	for _, addr := range addrs {
+		if sameIp(addr.IP.String(), ip) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (Netlink) AddDummy(name string) error {
+	return linkError("add", name, netlink.LinkAdd(&netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: name}}))
+}
+
+func (Netlink) SetMac(name string, mac string) error {
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return linkError("set mac", name, err)
+	}
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return linkError("set mac", name, err)
+	}
+	return linkError("set mac", name, netlink.LinkSetHardwareAddr(link, hw))
+}
+
+func (Netlink) AddAddr(name string, cidr string, label string) error {
+	link, addr, err := linkAddr(name, cidr, label)
+	if err != nil {
+		return linkError("add addr", name, err)
+	}
+	// the broadcast address is worked out like brd +
+	return linkError("add addr", name, netlink.AddrAdd(link, addr))
+}
+
+func (Netlink) SetUp(name string) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return linkError("set up", name, err)
+	}
+	return linkError("set up", name, netlink.LinkSetUp(link))
+}
+
+func (Netlink) DelAddr(name string, cidr string, label string) error {
+	link, addr, err := linkAddr(name, cidr, label)
+	if err != nil {
+		return linkError("del addr", name, err)
+	}
+	return linkError("del addr", name, netlink.AddrDel(link, addr))
+}
+
+func (Netlink) DelLink(name string) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return linkError("del", name, err)
+	}
+	return linkError("del", name, netlink.LinkDel(link))
+}
+
+func linkAddr(name string, cidr string, label string) (netlink.Link, *netlink.Addr, error) {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	addr, err := netlink.ParseAddr(cidr)
+	if err != nil {
+		return nil, nil, err
+	}
+	addr.Label = label
+	return link, addr, nil
+}
+
+// linkError types what the kernel said
+func linkError(op string, link string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var notFound netlink.LinkNotFoundError
+	switch {
+	case errors.Is(err, syscall.EEXIST):
+		err = ErrExists
+	case errors.As(err, &notFound), errors.Is(err, syscall.ENODEV), errors.Is(err, syscall.EADDRNOTAVAIL):
+		err = ErrNotFound
+	}
+	return &LinkError{Op: op, Link: link, Err: err}
+}
@@ -1,27 +1,30 @@
 package network
 
 import (
-	"crypto/rand"
+	"errors"
 	"net"
 	"net/netip"
 	"strings"
 )
 
-func GenerateMac() string {
-	buf := make([]byte, 6)
-	var mac net.HardwareAddr
-	_, err := rand.Read(buf)
+// IpMac is a locally administered MAC made from an IPv4 address, the same every time a node is made
+func IpMac(ip string) (string, error) {
+	addr, err := netip.ParseAddr(ip)
 	if err != nil {
+		return "", err
 	}
-	buf[0] = (buf[0] | 0x02) & 0xfe
-	mac = append(mac, buf[0], buf[1], buf[2], buf[3], buf[4], buf[5])
-	return strings.ToUpper(mac.String())
+	if !addr.Is4() {
+		return "", errors.New("cannot make a mac from " + ip + ", nodes need ipv4 addresses")
+	}
+	b := addr.As4()
+	mac := net.HardwareAddr{0x6e, 0x6d, b[0], b[1], b[2], b[3]}
+	return strings.ToUpper(mac.String()), nil
 }
 
 func CidrToIps(cidr string) ([]string, error) {
 	prefix, err := netip.ParsePrefix(cidr)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	var ips []string
 	for addr := prefix.Addr(); prefix.Contains(addr); addr = addr.Next() {
@@ -44,9 +47,9 @@ func GetIpFromDevice(device string) (ip string) {
 			if err != nil {
 				return ip
 			}
+			// the address without its prefix length, whatever the length is
 			for _, addr := range addrs {
-				ip = addr.String()
-				ip = strings.TrimRight(ip, "/24")
+				ip, _, _ = strings.Cut(addr.String(), "/")
 				return ip
 			}
 		}
@@ -0,0 +1,153 @@
+package network
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mmcquillan/nomad-box/run"
+)
+
+func TestSameIp(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want bool
+	}{
+		{"10.10.10.1", "10.10.10.1", true},
+		{"10.10.10.1/24", "10.10.10.1", true},
+		{"10.10.10.1", "10.10.10.12", false},
+		{"10.10.10.12/24", "10.10.10.1/24", false},
+		{"10.10.10.1", "10.10.10.10", false},
+		{"fe80::1/64", "fe80::1", true},
+		{"", "", false},
+		{"nmdeth0", "nmdeth0", false},
+	}
+	for _, c := range cases {
+		if got := sameIp(c.a, c.b); got != c.want {
+			t.Errorf("sameIp(%q, %q) = %v, want %v", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestIpLinkExists(t *testing.T) {
+	recorder := &run.Recorder{Outputs: map[string]string{
+		"ip -o link show": "1: lo: <LOOPBACK,UP,LOWER_UP> mtu 65536 qdisc noqueue state UNKNOWN\n" +
+			"3: nmdeth1: <BROADCAST,NOARP,UP,LOWER_UP> mtu 1500 qdisc noqueue state UNKNOWN\n" +
+			"4: nmdeth0p@if5: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc noqueue master nmdbr0\n",
+	}}
+	backend := Ip{Runner: recorder}
+
+	cases := map[string]bool{
+		"nmdeth1":  true,
+		"nmdeth0p": true,
+		"nmdeth0":  false,
+		"nmdeth":   false,
+		"lo":       true,
+	}
+	for name, want := range cases {
+		found, err := backend.LinkExists(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found != want {
+			t.Errorf("LinkExists(%q) = %v, want %v", name, found, want)
+		}
+	}
+}
+
+func TestIpAddrExists(t *testing.T) {
+	recorder := &run.Recorder{Outputs: map[string]string{
+		"ip -o addr show": "1: lo    inet 127.0.0.1/8 scope host lo\\       valid_lft forever preferred_lft forever\n" +
+			"3: nmdeth1    inet 10.10.10.12/24 brd 10.10.10.255 scope global nmdeth1:0\\       valid_lft forever\n" +
+			"3: nmdeth1    inet6 fe80::42:aff:fe0a:a0c/64 scope link \\       valid_lft forever\n",
+	}}
+	backend := Ip{Runner: recorder}
+
+	cases := map[string]bool{
+		"10.10.10.12":             true,
+		"10.10.10.1":              false,
+		"10.10.10.255":            false,
+		"127.0.0.1":               true,
+		"fe80::42:aff:fe0a:a0c":   true,
+		"10.10.10.12/24":          true,
+		"fe80::42:aff:fe0a:a0c/8": true,
+	}
+	for ip, want := range cases {
+		found, err := backend.AddrExists(ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found != want {
+			t.Errorf("AddrExists(%q) = %v, want %v", ip, found, want)
+		}
+	}
+}
+
+func TestIpError(t *testing.T) {
+	cases := map[string]error{
+		"RTNETLINK answers: File exists":                     ErrExists,
+		"Cannot find device \"nmdeth0\"":                     ErrNotFound,
+		"RTNETLINK answers: Cannot assign requested address": ErrNotFound,
+	}
+	for stderr, want := range cases {
+		err := ipError("del", "nmdeth0", &run.CommandError{Command: "ip", ExitCode: 2, Stderr: stderr, Err: errors.New("exit status 2")})
+		var link *LinkError
+		if !errors.As(err, &link) || link.Link != "nmdeth0" {
+			t.Errorf("%s: not a LinkError for nmdeth0: %v", stderr, err)
+		}
+		if !errors.Is(err, want) {
+			t.Errorf("%s: %v is not %v", stderr, err, want)
+		}
+	}
+}
+
+func TestFake(t *testing.T) {
+	fake := NewFake()
+	if err := fake.AddDummy("nmdeth0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.AddDummy("nmdeth0"); !errors.Is(err, ErrExists) {
+		t.Errorf("second add is %v, want ErrExists", err)
+	}
+	if err := fake.AddAddr("nmdeth0", "10.10.10.12/24", "nmdeth0:0"); err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := fake.AddrExists("10.10.10.1"); found {
+		t.Error("10.10.10.1 matched 10.10.10.12")
+	}
+	if found, _ := fake.AddrExists("10.10.10.12"); !found {
+		t.Error("10.10.10.12 not found")
+	}
+	if err := fake.DelAddr("nmdeth0", "10.10.10.1/24", "nmdeth0:0"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleting a missing address is %v, want ErrNotFound", err)
+	}
+	if err := fake.SetMac("nmdeth1", "6E:6D:0A:0A:0A:01"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("mac on a missing link is %v, want ErrNotFound", err)
+	}
+	if err := fake.DelLink("nmdeth0"); err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := fake.LinkExists("nmdeth0"); found {
+		t.Error("nmdeth0 still exists")
+	}
+}
+
+func TestIpMac(t *testing.T) {
+	if mac, err := IpMac("10.10.10.1"); err != nil || mac != "6E:6D:0A:0A:0A:01" {
+		t.Errorf("IpMac is %s %v", mac, err)
+	}
+	for _, ip := range []string{"fe80::1", "", "bogus"} {
+		if mac, err := IpMac(ip); err == nil {
+			t.Errorf("IpMac of %q is %s", ip, mac)
+		}
+	}
+}
+
+func TestCidrToIps(t *testing.T) {
+	ips, err := CidrToIps("10.10.10.0/30")
+	if err != nil || len(ips) != 2 || ips[0] != "10.10.10.1" || ips[1] != "10.10.10.2" {
+		t.Errorf("CidrToIps is %v %v", ips, err)
+	}
+	if _, err := CidrToIps("bogus"); err == nil {
+		t.Error("bogus cidr did not fail")
+	}
+}
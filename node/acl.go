@@ -0,0 +1,117 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mmcquillan/nomad-box/api"
+	"github.com/mmcquillan/nomad-box/config"
+	"github.com/mmcquillan/nomad-box/run"
+)
+
+func ACLDir(cfg config.Config) string {
+	return cfg.Directory + "/acl"
+}
+
+func ACLToken(cfg config.Config, name string) string {
+	return ACLDir(cfg) + "/" + name + ".token"
+}
+
+// BootstrapACL waits for a leader to bootstrap with, cancelling ctx stops the wait
+func BootstrapACL(ctx context.Context, cfg config.Config, nodes []Node) (token string, err error) {
+
+	// already bootstrapped for a persisted cluster
+	if secret, err := os.ReadFile(ACLToken(cfg, "management")); err == nil {
+		return strings.TrimSpace(string(secret)), nil
+	}
+
+	run.Header("Bootstrapping ACLs")
+	server := regionServer(nodes, nodes[0].Region, "")
+	if server < 0 {
+		return token, errors.New("no server to bootstrap ACLs")
+	}
+	client := apiClient(cfg, nodes[server])
+
+	// bootstrap once a leader exists
+	timeout := cfg.ReadyTimeout
+	if timeout <= 0 {
+		timeout = 2 * time.Minute
+	}
+	var bootstrap api.ACLToken
+	var done error
+	err = poll(ctx, time.Now().Add(timeout), func() error {
+		bootstrap, err = client.BootstrapACL()
+		if err != nil && strings.Contains(err.Error(), "ACL bootstrap already done") {
+			done = err
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return token, err
+	}
+
+	// bootstrapped by someone else, retrying never gets the token back
+	if done != nil {
+		return token, errors.New("ACLs are already bootstrapped and " + ACLToken(cfg, "management") + " is missing, reset the bootstrap or start with a clean directory: " + done.Error())
+	}
+
+	// keep the management token with the cluster state
+	if err := run.Command(cfg.Runner, "mkdir -p "+ACLDir(cfg)); err != nil {
+		return token, err
+	}
+	if err := runner(cfg).WriteFile(ACLToken(cfg, "management"), []byte(bootstrap.SecretID+"\n"), 0600); err != nil {
+		return token, err
+	}
+	client.Token = bootstrap.SecretID
+
+	// policies and a token for each of them
+	if cfg.ACLPolicies != "" {
+		files, err := filepath.Glob(cfg.ACLPolicies + "/*.hcl")
+		if err != nil {
+			return token, err
+		}
+		for _, file := range files {
+			rules, err := os.ReadFile(file)
+			if err != nil {
+				return token, err
+			}
+			name := strings.TrimSuffix(filepath.Base(file), ".hcl")
+			run.Out("Applying Policy " + name)
+			err = client.UpsertACLPolicy(api.ACLPolicy{Name: name, Description: "nomad-box " + file, Rules: string(rules)})
+			if err != nil {
+				return token, err
+			}
+			created, err := client.CreateACLToken(api.ACLToken{Name: name, Type: "client", Policies: []string{name}})
+			if err != nil {
+				return token, err
+			}
+			if err := runner(cfg).WriteFile(ACLToken(cfg, name), []byte(created.SecretID+"\n"), 0600); err != nil {
+				return token, err
+			}
+			run.Out("Token " + name + " => " + ACLToken(cfg, name))
+		}
+	}
+
+	return bootstrap.SecretID, nil
+}
+
+func writeACLConfig(cfg config.Config) error {
+	config := []byte(`acl {
+  enabled = true
+}
+`)
+	return runner(cfg).WriteFile(aclConfigFile(cfg), config, 0644)
+}
+
+func cleanACL(cfg config.Config) {
+	run.Command(cfg.Runner, "rm -rf "+ACLDir(cfg)+" "+aclConfigFile(cfg))
+}
+
+func aclConfigFile(cfg config.Config) string {
+	return cfg.Directory + "/acl-config.hcl"
+}
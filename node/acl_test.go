@@ -0,0 +1,73 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBootstrapACL(t *testing.T) {
+	fake := serveNomad(t, "127.0.0.1")
+	fake.secret = "s3cr3t"
+	cfg, recorder := testConfig(t)
+	cfg.ACL = true
+	nodes := []Node{{Name: "nmds0", Server: true, Region: "global", Ip: "127.0.0.1"}}
+
+	token, err := BootstrapACL(context.Background(), cfg, nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "s3cr3t" {
+		t.Errorf("token is %s", token)
+	}
+
+	// the token is kept through the runner, readable only by its owner
+	if len(recorder.Steps) != 2 || recorder.Steps[0].Command != "mkdir -p /tmp/nmd-test/acl" {
+		t.Fatalf("steps %+v", recorder.Steps)
+	}
+	write := recorder.Steps[1]
+	if write.Path != "/tmp/nmd-test/acl/management.token" || write.Mode != "-rw-------" || write.Content != "s3cr3t\n" {
+		t.Errorf("token write is %+v", write)
+	}
+}
+
+func TestBootstrapACLAlreadyDone(t *testing.T) {
+	serveNomad(t, "127.0.0.1")
+	cfg, recorder := testConfig(t)
+	cfg.ACL = true
+	cfg.ReadyTimeout = time.Minute
+	nodes := []Node{{Name: "nmds0", Server: true, Region: "global", Ip: "127.0.0.1"}}
+
+	// no retries until the timeout
+	start := time.Now()
+	_, err := BootstrapACL(context.Background(), cfg, nodes)
+	if err == nil || !strings.Contains(err.Error(), "already bootstrapped") {
+		t.Errorf("error is %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("took %s to fail", time.Since(start))
+	}
+	if len(recorder.Commands) != 0 {
+		t.Errorf("ran %v", recorder.Commands)
+	}
+}
+
+func TestBootstrapACLCancelled(t *testing.T) {
+	cfg, _ := testConfig(t)
+	cfg.ACL = true
+	cfg.ReadyTimeout = time.Minute
+	nodes := []Node{{Name: "nmds0", Server: true, Region: "global", Ip: "127.0.0.9"}}
+
+	// nothing answers, the cancelled wait ends at once
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	if _, err := BootstrapACL(ctx, cfg, nodes); !errors.Is(err, context.Canceled) {
+		t.Errorf("error is %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("took %s to stop", time.Since(start))
+	}
+}
@@ -0,0 +1,155 @@
+package node
+
+import (
+	"math/rand"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmcquillan/nomad-box/config"
+	"github.com/mmcquillan/nomad-box/run"
+)
+
+func ChaosNodes(cfg config.Config, nodes []Node, chaos config.Chaos) {
+
+	run.Header("Chaos")
+
+	// seeded so a schedule can be replayed
+	seed := chaos.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(seed))
+	actions := strings.Split(chaos.Actions, ",")
+	chaosLog(cfg, "start seed="+strconv.FormatInt(seed, 10)+" actions="+chaos.Actions+" targets="+chaos.Targets)
+
+	// stop on interrupt or when the duration is up
+	q := make(chan os.Signal, 1)
+	signal.Notify(q, os.Interrupt)
+	defer signal.Stop(q)
+	var end <-chan time.Time
+	if chaos.Duration > 0 {
+		end = time.After(chaos.Duration)
+	}
+	tick := time.NewTicker(chaos.Interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-q:
+			chaosLog(cfg, "stop interrupted")
+			return
+		case <-end:
+			chaosLog(cfg, "stop duration reached")
+			return
+		case <-tick.C:
+		}
+
+		// pick an action and a node it can be applied to
+		action, i := chaosPick(cfg, nodes, rng, actions, chaos)
+		if i < 0 {
+			chaosLog(cfg, "skip "+action+" no eligible nodes")
+			continue
+		}
+
+		switch action {
+		case "kill":
+			chaosLog(cfg, "kill "+nodes[i].Name+" pid="+strconv.Itoa(nodes[i].Pid))
+			run.Command(cfg.Runner, "kill -9 "+strconv.Itoa(nodes[i].Pid))
+		case "pause":
+			chaosLog(cfg, "pause "+nodes[i].Name+" pid="+strconv.Itoa(nodes[i].Pid)+" for "+chaos.Pause.String())
+			run.Command(cfg.Runner, "kill -STOP "+strconv.Itoa(nodes[i].Pid))
+			select {
+			case <-time.After(chaos.Pause):
+			case <-q:
+				run.Command(cfg.Runner, "kill -CONT "+strconv.Itoa(nodes[i].Pid))
+				chaosLog(cfg, "resume "+nodes[i].Name+" pid="+strconv.Itoa(nodes[i].Pid))
+				chaosLog(cfg, "stop interrupted")
+				return
+			}
+			run.Command(cfg.Runner, "kill -CONT "+strconv.Itoa(nodes[i].Pid))
+			chaosLog(cfg, "resume "+nodes[i].Name+" pid="+strconv.Itoa(nodes[i].Pid))
+		case "restart":
+			chaosLog(cfg, "restart "+nodes[i].Name+" pid="+strconv.Itoa(nodes[i].Pid))
+			if err := RestartNode(cfg, nodes, i); err != nil {
+				chaosLog(cfg, "restart "+nodes[i].Name+" failed: "+err.Error())
+			} else {
+				chaosLog(cfg, "started "+nodes[i].Name+" pid="+strconv.Itoa(nodes[i].Pid))
+			}
+		}
+
+		// keep the state current for status and down
+		if err := SaveState(cfg, nodes); err != nil {
+			run.Error("Cannot Save State")
+			run.Error(err.Error())
+		}
+	}
+
+}
+
+func RestartNode(cfg config.Config, nodes []Node, i int) error {
+
+	// agents started from here outlive this process
+	cfg.Detach = true
+	cleanNodeProcess(cfg, stopNode(nodes, i))
+	return startNode(cfg, nodes, i)
+
+}
+
+// chaosPick draws the next action and a node it applies to from rng, the node is -1 when none is eligible
+func chaosPick(cfg config.Config, nodes []Node, rng *rand.Rand, actions []string, chaos config.Chaos) (string, int) {
+	action := strings.TrimSpace(actions[rng.Intn(len(actions))])
+	var candidates []int
+	for i := 0; i < len(nodes); i++ {
+		if chaosTarget(cfg, nodes, i, action, chaos) {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) == 0 {
+		return action, -1
+	}
+	return action, candidates[rng.Intn(len(candidates))]
+}
+
+func chaosTarget(cfg config.Config, nodes []Node, i int, action string, chaos config.Chaos) bool {
+
+	// node type
+	if chaos.Targets == "servers" && !nodes[i].Server {
+		return false
+	}
+	if chaos.Targets == "clients" && nodes[i].Server {
+		return false
+	}
+
+	// restart brings back dead nodes too, the rest need a live agent
+	running := nodes[i].Pid > 0 && run.CheckProcess(nodes[i].Pid)
+	if action != "restart" && !running {
+		return false
+	}
+
+	// never take a region below quorum
+	if chaos.Quorum && nodes[i].Server && running {
+		total, alive := 0, 0
+		for j := 0; j < len(nodes); j++ {
+			if nodes[j].Server && nodes[j].Region == nodes[i].Region {
+				total++
+				if nodes[j].Pid > 0 && run.CheckProcess(nodes[j].Pid) {
+					alive++
+				}
+			}
+		}
+		if alive-1 < total/2+1 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func chaosLog(cfg config.Config, msg string) {
+	line := time.Now().Format(time.RFC3339) + " " + msg
+	run.Out(line)
+	runner(cfg).AppendFile(cfg.Directory+"/chaos.log", []byte(line+"\n"), 0644)
+}
@@ -0,0 +1,120 @@
+package node
+
+import (
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mmcquillan/nomad-box/config"
+	"github.com/mmcquillan/nomad-box/run"
+)
+
+// chaosNodes are three servers and two clients, all alive but the last client
+func chaosNodes(t *testing.T) (config.Config, []Node, *run.Recorder) {
+	cfg, recorder := testConfig(t)
+	cfg.Servers = 3
+	cfg.Clients = 2
+	cfg.Groups[0].Count = 3
+	cfg.Groups[1].Count = 2
+	cfg.Ips = []string{"10.10.10.1", "10.10.10.2", "10.10.10.3", "10.10.10.4", "10.10.10.5"}
+	nodes := MakeNodes(cfg)
+	for i := 0; i < len(nodes)-1; i++ {
+		nodes[i].Pid = os.Getpid()
+	}
+	return cfg, nodes, recorder
+}
+
+func TestChaosPickSeeded(t *testing.T) {
+	cfg, nodes, _ := chaosNodes(t)
+	chaos := config.Defaults().Chaos
+	actions := strings.Split(chaos.Actions, ",")
+
+	plan := func(seed int64) (picks []string) {
+		rng := rand.New(rand.NewSource(seed))
+		for n := 0; n < 50; n++ {
+			action, i := chaosPick(cfg, nodes, rng, actions, chaos)
+			picks = append(picks, action+" "+strconv.Itoa(i))
+		}
+		return picks
+	}
+
+	// the same seed replays the same schedule
+	first := plan(42)
+	if again := plan(42); strings.Join(again, ",") != strings.Join(first, ",") {
+		t.Errorf("seed 42 gave\n%v\nthen\n%v", first, again)
+	}
+	if other := plan(43); strings.Join(other, ",") == strings.Join(first, ",") {
+		t.Error("seeds 42 and 43 gave the same schedule")
+	}
+
+	// only restart reaches the dead client
+	for _, pick := range first {
+		if strings.HasSuffix(pick, " 4") && !strings.HasPrefix(pick, "restart ") {
+			t.Errorf("%s picked the dead client", pick)
+		}
+	}
+}
+
+func TestChaosQuorum(t *testing.T) {
+	cases := []struct {
+		name   string
+		dead   []int
+		quorum bool
+		action string
+		node   int
+		want   bool
+	}{
+		{name: "all servers alive", quorum: true, action: "kill", node: 0, want: true},
+		{name: "a server down", dead: []int{1}, quorum: true, action: "kill", node: 0, want: false},
+		{name: "a server down without the guard", dead: []int{1}, quorum: false, action: "kill", node: 0, want: true},
+		{name: "restart a dead server", dead: []int{1}, quorum: true, action: "restart", node: 1, want: true},
+		{name: "kill a dead server", dead: []int{1}, quorum: true, action: "kill", node: 1, want: false},
+		{name: "clients ignore the quorum", dead: []int{0, 1}, quorum: true, action: "kill", node: 3, want: true},
+	}
+	for _, c := range cases {
+		cfg, nodes, _ := chaosNodes(t)
+		for _, i := range c.dead {
+			nodes[i].Pid = 0
+		}
+		chaos := config.Defaults().Chaos
+		chaos.Quorum = c.quorum
+		if got := chaosTarget(cfg, nodes, c.node, c.action, chaos); got != c.want {
+			t.Errorf("%s: %s of %s is %t", c.name, c.action, nodes[c.node].Name, got)
+		}
+	}
+}
+
+func TestChaosLog(t *testing.T) {
+	cfg, nodes, recorder := chaosNodes(t)
+	chaos := config.Chaos{Seed: 7, Interval: 20 * time.Millisecond, Duration: 110 * time.Millisecond, Actions: "kill", Targets: "clients", Quorum: true}
+
+	ChaosNodes(cfg, nodes, chaos)
+
+	// every action is logged through the runner between the start and the stop
+	log := string(recorder.Files[cfg.Directory+"/chaos.log"])
+	lines := strings.Split(strings.TrimSpace(log), "\n")
+	if len(lines) < 3 {
+		t.Fatalf("log is %q", log)
+	}
+	if !strings.HasSuffix(lines[0], " start seed=7 actions=kill targets=clients") {
+		t.Errorf("first line is %q", lines[0])
+	}
+	if !strings.HasSuffix(lines[len(lines)-1], " stop duration reached") {
+		t.Errorf("last line is %q", lines[len(lines)-1])
+	}
+	kill := " kill nmdc0 pid=" + strconv.Itoa(os.Getpid())
+	for _, line := range lines[1 : len(lines)-1] {
+		if !strings.HasSuffix(line, kill) {
+			t.Errorf("line is %q, want %q", line, kill)
+		}
+		if _, err := time.Parse(time.RFC3339, strings.Fields(line)[0]); err != nil {
+			t.Errorf("line %q has no timestamp", line)
+		}
+	}
+	if !contains(recorder.Commands, "kill -9 "+strconv.Itoa(os.Getpid())) {
+		t.Errorf("commands %v", recorder.Commands)
+	}
+}
@@ -0,0 +1,57 @@
+package node
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/mmcquillan/nomad-box/config"
+	"github.com/mmcquillan/nomad-box/run"
+)
+
+func hasClientConfig(node Node) bool {
+	return !node.Server && (len(node.Meta) > 0 || node.NodeClass != "" || len(node.HostVolumes) > 0)
+}
+
+func makeHostVolumes(cfg config.Config, node Node) error {
+	for _, v := range node.HostVolumes {
+		if err := run.Command(cfg.Runner, "mkdir -p "+hostVolumePath(node, v.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeClientConfig(cfg config.Config, node Node) error {
+	config := "client {\n"
+	if node.NodeClass != "" {
+		config += "  node_class = " + strconv.Quote(node.NodeClass) + "\n"
+	}
+	if len(node.Meta) > 0 {
+		keys := make([]string, 0, len(node.Meta))
+		for k := range node.Meta {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		config += "  meta {\n"
+		for _, k := range keys {
+			config += "    " + strconv.Quote(k) + " = " + strconv.Quote(node.Meta[k]) + "\n"
+		}
+		config += "  }\n"
+	}
+	for _, v := range node.HostVolumes {
+		config += "  host_volume " + strconv.Quote(v.Name) + " {\n"
+		config += "    path      = " + strconv.Quote(hostVolumePath(node, v.Name)) + "\n"
+		config += "    read_only = " + strconv.FormatBool(v.ReadOnly) + "\n"
+		config += "  }\n"
+	}
+	config += "}\n"
+	return runner(cfg).WriteFile(clientConfigFile(node), []byte(config), 0644)
+}
+
+func hostVolumePath(node Node, name string) string {
+	return node.Dir + "/volumes/" + name
+}
+
+func clientConfigFile(node Node) string {
+	return node.Dir + "/client.hcl"
+}
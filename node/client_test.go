@@ -0,0 +1,72 @@
+package node
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mmcquillan/nomad-box/config"
+	"github.com/mmcquillan/nomad-box/run"
+)
+
+func TestWriteClientConfig(t *testing.T) {
+	cfg, _ := testConfig(t)
+	cfg.Runner = run.Shell{}
+	cfg.Directory = t.TempDir()
+	cfg.Groups[1].Meta = map[string]string{"rack": "r1", "az": "us-east-1a", "quoted": `say "hi"`}
+	cfg.Groups[1].NodeClass = "gpu"
+	cfg.Groups[1].HostVolumes = []config.HostVolume{{Name: "data"}, {Name: "certs", ReadOnly: true}}
+	nodes := MakeNodes(cfg)
+	client := nodes[1]
+	if !hasClientConfig(client) || hasClientConfig(nodes[0]) {
+		t.Fatal("only the client has a client config")
+	}
+	if err := os.MkdirAll(client.Dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := makeHostVolumes(cfg, client); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeClientConfig(cfg, client); err != nil {
+		t.Fatal(err)
+	}
+
+	// a directory for each volume
+	for _, name := range []string{"data", "certs"} {
+		if info, err := os.Stat(client.Dir + "/volumes/" + name); err != nil || !info.IsDir() {
+			t.Errorf("volume %s: %v", name, err)
+		}
+	}
+
+	// meta sorted and quoted, volumes in spec order
+	hcl, err := os.ReadFile(clientConfigFile(client))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `client {
+  node_class = "gpu"
+  meta {
+    "az" = "us-east-1a"
+    "quoted" = "say \"hi\""
+    "rack" = "r1"
+  }
+  host_volume "data" {
+    path      = "` + client.Dir + `/volumes/data"
+    read_only = false
+  }
+  host_volume "certs" {
+    path      = "` + client.Dir + `/volumes/certs"
+    read_only = true
+  }
+}
+`
+	if string(hcl) != want {
+		t.Errorf("client.hcl is\n%s\nwant\n%s", hcl, want)
+	}
+
+	// the agent loads it
+	if args := strings.Join(agentArgs(cfg, nodes, 1), " "); !strings.Contains(args, "-config="+clientConfigFile(client)) {
+		t.Errorf("agent args %s", args)
+	}
+}
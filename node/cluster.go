@@ -0,0 +1,62 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+
+	"github.com/mmcquillan/nomad-box/config"
+	"github.com/mmcquillan/nomad-box/run"
+)
+
+// CheckRunning fails when the directory already has a running cluster
+func CheckRunning(cfg config.Config) error {
+	state, err := LoadState(cfg)
+	if err != nil {
+		return nil
+	}
+	for _, n := range state.Nodes {
+		if n.Pid > 0 && run.CheckProcess(n.Pid) {
+			return errors.New("cluster already running in " + cfg.Directory + " (use down)")
+		}
+	}
+	return nil
+}
+
+// CheckOwner fails when another process supervises the cluster, its nodes only change through that process
+func CheckOwner(state State) error {
+	if state.Owner > 0 && state.Owner != os.Getpid() && run.CheckProcess(state.Owner) {
+		return errors.New("cluster is attached to nomad-box up pid " + strconv.Itoa(state.Owner) + ", use its console or control api")
+	}
+	return nil
+}
+
+// StartNodes builds and starts the nodes, bootstraps acls and waits until they are ready
+func StartNodes(ctx context.Context, cfg config.Config, nodes []Node) (token string, err error) {
+
+	// start up nodes, they are rolled back when one fails
+	if err := BuildNodes(cfg, nodes); err != nil {
+		return "", err
+	}
+
+	// record the cluster
+	if err := SaveState(cfg, nodes); err != nil {
+		run.Error("Cannot Save State")
+		run.Error(err.Error())
+	}
+
+	// bootstrap acls once there is a leader
+	if cfg.ACL {
+		token, err = BootstrapACL(ctx, cfg, nodes)
+		if err != nil {
+			return token, &PhaseError{Phase: PhaseReady, Err: errors.New("cannot bootstrap ACLs: " + err.Error())}
+		}
+	}
+
+	// wait for a leader and registered clients
+	if err := WaitNodes(ctx, cfg, nodes); err != nil {
+		return token, &PhaseError{Phase: PhaseReady, Err: errors.New("cluster not ready: " + err.Error())}
+	}
+	return token, nil
+}
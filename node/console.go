@@ -0,0 +1,272 @@
+package node
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/mmcquillan/nomad-box/config"
+	"github.com/mmcquillan/nomad-box/run"
+)
+
+// Console reads commands until quit or the end of input, cancelling ctx stops the waits of a command
+func Console(ctx context.Context, state *State, in io.Reader) {
+
+	run.Out("Cluster Running (type help for commands, quit to stop)")
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(run.Output, "nomad-box> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(run.Output)
+			return
+		}
+		args := strings.Fields(scanner.Text())
+		if len(args) == 0 {
+			continue
+		}
+		if args[0] == "quit" || args[0] == "exit" {
+			return
+		}
+		busy.Lock()
+		if err := consoleCommand(ctx, state, args); err != nil {
+			run.Error(err.Error())
+		}
+		saveCurrent(state)
+		busy.Unlock()
+	}
+
+}
+
+func consoleCommand(ctx context.Context, state *State, args []string) error {
+
+	// commands without a node
+	cfg, nodes := current(state)
+	switch args[0] {
+	case "help":
+		consoleHelp()
+		return nil
+	case "status":
+		lock.Lock()
+		snapshot := append([]Node(nil), nodes...)
+		lock.Unlock()
+		StatusNodes(cfg, snapshot)
+		return nil
+	case "leader":
+		return consoleLeader(cfg, nodes)
+	case "partition":
+		if len(args) != 3 {
+			return errors.New("usage: partition <nodes> <nodes>")
+		}
+		if _, err := exec.LookPath("iptables"); err != nil {
+			return errors.New("iptables is not installed")
+		}
+		between, err := FindNodes(cfg, nodes, args[1])
+		if err != nil {
+			return err
+		}
+		and, err := FindNodes(cfg, nodes, args[2])
+		if err != nil {
+			return err
+		}
+		return PartitionNodes(cfg, between, and)
+	case "heal":
+		HealNodes(cfg, nodes)
+		return nil
+	case "scale":
+		clients := -1
+		if len(args) == 2 {
+			if n, err := strconv.Atoi(args[1]); err == nil {
+				clients = n
+			}
+		}
+		if clients < 0 {
+			return errors.New("usage: scale <clients>")
+		}
+		return changeCurrent(state, func(cfg config.Config, nodes []Node) (config.Config, []Node, error) {
+			return ScaleNodes(ctx, cfg, nodes, clients)
+		})
+	case "add-node":
+		group := ""
+		if len(args) > 1 {
+			group = args[1]
+		}
+		return changeCurrent(state, func(cfg config.Config, nodes []Node) (config.Config, []Node, error) {
+			return AddNode(ctx, cfg, nodes, group)
+		})
+	case "remove-node":
+		if len(args) != 2 {
+			return errors.New("usage: remove-node <node>")
+		}
+		i, err := FindNode(cfg, nodes, args[1])
+		if err != nil {
+			return err
+		}
+		return changeCurrent(state, func(cfg config.Config, nodes []Node) (config.Config, []Node, error) {
+			return RemoveNode(ctx, cfg, nodes, i)
+		})
+	}
+
+	// commands on a node
+	switch args[0] {
+	case "logs", "kill", "stop", "start", "restart":
+	default:
+		return errors.New("unknown command " + args[0] + " (try help)")
+	}
+	if len(args) < 2 {
+		return errors.New("usage: " + args[0] + " <node> (try help)")
+	}
+	if args[0] == "logs" {
+		i, err := FindNode(cfg, nodes, args[1])
+		if err != nil {
+			return err
+		}
+		lines := 20
+		if len(args) > 2 {
+			if lines, err = strconv.Atoi(args[2]); err != nil {
+				return errors.New("usage: logs <node> [lines]")
+			}
+		}
+		return consoleLogs(nodes[i], lines)
+	}
+	return nodeAction(cfg, nodes, args[1], args[0])
+
+}
+
+// NodeAction kills, stops, starts or restarts a node of a running cluster
+func NodeAction(state *State, name string, action string) error {
+	busy.Lock()
+	defer busy.Unlock()
+	cfg, nodes := current(state)
+	if err := nodeAction(cfg, nodes, name, action); err != nil {
+		return err
+	}
+	saveCurrent(state)
+	return nil
+}
+
+func nodeAction(cfg config.Config, nodes []Node, name string, action string) error {
+	i, err := FindNode(cfg, nodes, name)
+	if err != nil {
+		return err
+	}
+	lock.Lock()
+	pid := nodes[i].Pid
+	lock.Unlock()
+	running := pid > 0 && run.CheckProcess(pid)
+	switch action {
+	case "kill":
+		if !running {
+			return errors.New(nodes[i].Name + " is not running")
+		}
+		run.Out("Killing " + nodes[i].Name + " pid=" + strconv.Itoa(pid))
+		run.Command(cfg.Runner, "kill -9 "+strconv.Itoa(pid))
+	case "stop":
+		if !running {
+			return errors.New(nodes[i].Name + " is not running")
+		}
+		run.Out("Stopping " + nodes[i].Name)
+		cleanNodeProcess(cfg, stopNode(nodes, i))
+	case "start":
+		if running {
+			return errors.New(nodes[i].Name + " is already running")
+		}
+		run.Out("Starting " + nodes[i].Name)
+		return startNode(cfg, nodes, i)
+	case "restart":
+		run.Out("Restarting " + nodes[i].Name)
+		cleanNodeProcess(cfg, stopNode(nodes, i))
+		return startNode(cfg, nodes, i)
+	default:
+		return errors.New("unknown action " + action)
+	}
+	return nil
+}
+
+// the config and nodes of a running cluster as they are now
+func current(state *State) (config.Config, []Node) {
+	lock.Lock()
+	defer lock.Unlock()
+	return state.Config, state.Nodes
+}
+
+// changeCurrent adds or removes nodes of a running cluster and keeps what is left, busy is held by the caller
+func changeCurrent(state *State, change func(config.Config, []Node) (config.Config, []Node, error)) error {
+	cfg, nodes := current(state)
+	cfg, nodes, err := change(cfg, nodes)
+	lock.Lock()
+	state.Config = cfg
+	state.Nodes = nodes
+	lock.Unlock()
+	return err
+}
+
+// keep the state current for other nomad-box commands
+func saveCurrent(state *State) {
+	lock.Lock()
+	err := SaveState(state.Config, state.Nodes)
+	lock.Unlock()
+	if err != nil {
+		run.Error("Cannot Save State")
+		run.Error(err.Error())
+	}
+}
+
+func consoleHelp() {
+	run.Out("status                  report the nodes")
+	run.Out("logs <node> [lines]     show the end of a node's log")
+	run.Out("restart <node>          stop and start an agent")
+	run.Out("kill <node>             kill -9 an agent (restart policies apply)")
+	run.Out("stop <node>             stop an agent")
+	run.Out("start <node>            start a stopped agent")
+	run.Out("leader                  show the leader of every region")
+	run.Out("partition <nodes> <nodes>  partition two sets of nodes")
+	run.Out("heal                    remove all partitions")
+	run.Out("scale <clients>         add or remove clients until there are as many")
+	run.Out("add-node [group]        add a client to the named or last client group")
+	run.Out("remove-node <node>      drain, stop and clean a client")
+	run.Out("quit                    stop and clean the cluster")
+}
+
+func consoleLeader(cfg config.Config, nodes []Node) error {
+	for _, region := range regions(nodes) {
+		server := regionServer(nodes, region, "")
+		if server < 0 {
+			continue
+		}
+		leader, err := apiClient(cfg, nodes[server]).Leader()
+		if err != nil {
+			return err
+		}
+
+		// the leader is reported as ip:port
+		name := leader
+		for j := 0; j < len(nodes); j++ {
+			if nodes[j].Server && strings.HasPrefix(leader, nodes[j].Ip+":") {
+				name = nodes[j].Name + " (" + leader + ")"
+			}
+		}
+		run.Out(region + " leader " + name)
+	}
+	return nil
+}
+
+func consoleLogs(node Node, lines int) error {
+	log, err := os.ReadFile(LogFile(node))
+	if err != nil {
+		return err
+	}
+	all := strings.Split(strings.TrimRight(string(log), "\n"), "\n")
+	if len(all) > lines {
+		all = all[len(all)-lines:]
+	}
+	for _, line := range all {
+		fmt.Fprintln(run.Output, line)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/mmcquillan/nomad-box/run"
+)
+
+func TestConsoleCommand(t *testing.T) {
+	cases := []struct {
+		line string
+		err  string
+	}{
+		{line: "help"},
+		{line: "bogus", err: "unknown command bogus"},
+		{line: "stop", err: "usage: stop <node>"},
+		{line: "stop c9", err: "no node named c9"},
+		{line: "kill c0", err: "nmdc0 is not running"},
+		{line: "start c0"},
+		{line: "logs c9", err: "no node named c9"},
+		{line: "logs c0 lots", err: "usage: logs <node> [lines]"},
+		{line: "partition c0", err: "usage: partition <nodes> <nodes>"},
+		{line: "scale", err: "usage: scale <clients>"},
+		{line: "scale some", err: "usage: scale <clients>"},
+		{line: "scale -1", err: "usage: scale <clients>"},
+		{line: "remove-node", err: "usage: remove-node <node>"},
+		{line: "remove-node c9", err: "no node named c9"},
+	}
+	for _, c := range cases {
+		cfg, _ := testConfig(t)
+		state := &State{Config: cfg, Nodes: MakeNodes(cfg)}
+		err := consoleCommand(context.Background(), state, strings.Fields(c.line))
+		switch {
+		case c.err == "" && err != nil:
+			t.Errorf("%s: %v", c.line, err)
+		case c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)):
+			t.Errorf("%s: error is %v, want %s", c.line, err, c.err)
+		}
+	}
+}
+
+func TestConsoleInput(t *testing.T) {
+	out := &bytes.Buffer{}
+	run.Output = out
+	defer func() { run.Output = io.Discard }()
+	cfg, recorder := testConfig(t)
+	state := &State{Config: cfg, Nodes: MakeNodes(cfg)}
+
+	// blank lines are skipped, errors are reported and reading stops at quit
+	Console(context.Background(), state, strings.NewReader("\n  \nbogus\nstart c0\nquit\nstart s0\n"))
+	if !strings.Contains(out.String(), "ERROR => unknown command bogus") {
+		t.Errorf("console output is\n%s", out)
+	}
+	started := 0
+	for _, command := range recorder.Commands {
+		if strings.Contains(command, "nomad agent") {
+			started++
+		}
+	}
+	if started != 1 {
+		t.Errorf("%d agents started, want only nmdc0:\n%s", started, strings.Join(recorder.Commands, "\n"))
+	}
+
+	// the end of input stops it too
+	Console(context.Background(), state, strings.NewReader("help"))
+}
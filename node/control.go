@@ -0,0 +1,166 @@
+package node
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/mmcquillan/nomad-box/config"
+	"github.com/mmcquillan/nomad-box/run"
+)
+
+// NodeStatus is a node as reported by the control api
+type NodeStatus struct {
+	Node
+	Running bool
+}
+
+// actions the control api takes on a node
+var nodeActions = map[string]bool{"restart": true, "stop": true, "start": true, "kill": true}
+
+type scaleRequest struct {
+	Clients int `json:"clients"`
+}
+
+// Control serves the control api on a localhost address or unix:<path> until the listener is closed
+func Control(state *State, addr string, quit func()) (net.Listener, error) {
+
+	network := "tcp"
+	if strings.HasPrefix(addr, "unix:") {
+		network = "unix"
+		addr = strings.TrimPrefix(addr, "unix:")
+		os.Remove(addr)
+	}
+	listener, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	go http.Serve(listener, controlMux(state, quit))
+
+	run.Out("Control API on " + network + " " + addr)
+	return listener, nil
+}
+
+func controlMux(state *State, quit func()) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/nodes", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			controlError(w, http.StatusMethodNotAllowed, errors.New("use GET"))
+			return
+		}
+		_, nodes := current(state)
+		controlReply(w, nodeStatuses(nodes))
+	})
+	mux.HandleFunc("/nodes/", func(w http.ResponseWriter, r *http.Request) {
+		controlNode(state, w, r)
+	})
+	mux.HandleFunc("/scale", func(w http.ResponseWriter, r *http.Request) {
+		controlScale(state, w, r)
+	})
+	mux.HandleFunc("/cluster", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			controlError(w, http.StatusMethodNotAllowed, errors.New("use DELETE"))
+			return
+		}
+		controlReply(w, map[string]string{"status": "stopping"})
+		quit()
+	})
+	return mux
+}
+
+func controlNode(state *State, w http.ResponseWriter, r *http.Request) {
+
+	// /nodes/{name} or /nodes/{name}/{action}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/nodes/"), "/"), "/")
+	cfg, nodes := current(state)
+	i, err := FindNode(cfg, nodes, parts[0])
+	if err != nil {
+		controlError(w, http.StatusNotFound, err)
+		return
+	}
+	if len(parts) == 1 {
+		if r.Method != http.MethodGet {
+			controlError(w, http.StatusMethodNotAllowed, errors.New("use GET"))
+			return
+		}
+		controlReply(w, nodeStatuses(nodes[i : i+1])[0])
+		return
+	}
+	if len(parts) != 2 || !nodeActions[parts[1]] {
+		controlError(w, http.StatusNotFound, errors.New("no action "+strings.Join(parts[1:], "/")+", use POST /nodes/{name}/{restart,stop,start,kill}"))
+		return
+	}
+	if r.Method != http.MethodPost {
+		controlError(w, http.StatusMethodNotAllowed, errors.New("use POST"))
+		return
+	}
+
+	if err := NodeAction(state, parts[0], parts[1]); err != nil {
+		controlError(w, http.StatusConflict, err)
+		return
+	}
+
+	// the node may have been removed in the meantime
+	cfg, nodes = current(state)
+	i, err = FindNode(cfg, nodes, parts[0])
+	if err != nil {
+		controlError(w, http.StatusNotFound, err)
+		return
+	}
+	controlReply(w, nodeStatuses(nodes[i : i+1])[0])
+
+}
+
+func controlScale(state *State, w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		controlError(w, http.StatusMethodNotAllowed, errors.New("use POST"))
+		return
+	}
+	var req scaleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Clients < 0 {
+		controlError(w, http.StatusBadRequest, errors.New(`scale needs {"clients": N}`))
+		return
+	}
+
+	// added clients are supervised like the rest of the cluster
+	busy.Lock()
+	defer busy.Unlock()
+	err := changeCurrent(state, func(cfg config.Config, nodes []Node) (config.Config, []Node, error) {
+		return ScaleNodes(r.Context(), cfg, nodes, req.Clients)
+	})
+	saveCurrent(state)
+	if err != nil {
+		controlError(w, http.StatusInternalServerError, err)
+		return
+	}
+	_, nodes := current(state)
+	controlReply(w, nodeStatuses(nodes))
+
+}
+
+func nodeStatuses(nodes []Node) (statuses []NodeStatus) {
+	lock.Lock()
+	defer lock.Unlock()
+	statuses = make([]NodeStatus, 0, len(nodes))
+	for i := 0; i < len(nodes); i++ {
+		running := nodes[i].Pid > 0 && run.CheckProcess(nodes[i].Pid)
+		statuses = append(statuses, NodeStatus{Node: nodes[i], Running: running})
+	}
+	return statuses
+}
+
+func controlReply(w http.ResponseWriter, out interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+func controlError(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,56 @@
+package node
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestControlRoutes(t *testing.T) {
+	cases := []struct {
+		method string
+		path   string
+		body   string
+		code   int
+	}{
+		{method: "GET", path: "/nodes", code: http.StatusOK},
+		{method: "POST", path: "/nodes", code: http.StatusMethodNotAllowed},
+		{method: "GET", path: "/nodes/c0", code: http.StatusOK},
+		{method: "GET", path: "/nodes/nmdc0", code: http.StatusOK},
+		{method: "DELETE", path: "/nodes/c0", code: http.StatusMethodNotAllowed},
+		{method: "GET", path: "/nodes/c9", code: http.StatusNotFound},
+		{method: "POST", path: "/nodes/c9/stop", code: http.StatusNotFound},
+		{method: "POST", path: "/nodes/c0/bogus", code: http.StatusNotFound},
+		{method: "POST", path: "/nodes/c0/stop/now", code: http.StatusNotFound},
+		{method: "GET", path: "/nodes/c0/stop", code: http.StatusMethodNotAllowed},
+		{method: "POST", path: "/nodes/c0/kill", code: http.StatusConflict},
+		{method: "POST", path: "/nodes/c0/start", code: http.StatusOK},
+		{method: "GET", path: "/scale", code: http.StatusMethodNotAllowed},
+		{method: "POST", path: "/scale", body: "clients", code: http.StatusBadRequest},
+		{method: "POST", path: "/scale", body: `{"clients": -1}`, code: http.StatusBadRequest},
+		{method: "GET", path: "/cluster", code: http.StatusMethodNotAllowed},
+	}
+	for _, c := range cases {
+		cfg, _ := testConfig(t)
+		state := &State{Config: cfg, Nodes: MakeNodes(cfg)}
+		mux := controlMux(state, func() {})
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(c.method, c.path, strings.NewReader(c.body)))
+		if w.Code != c.code {
+			t.Errorf("%s %s is %d, want %d: %s", c.method, c.path, w.Code, c.code, w.Body.String())
+		}
+	}
+}
+
+func TestControlQuit(t *testing.T) {
+	cfg, _ := testConfig(t)
+	state := &State{Config: cfg, Nodes: MakeNodes(cfg)}
+	quit := false
+	mux := controlMux(state, func() { quit = true })
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("DELETE", "/cluster", nil))
+	if w.Code != http.StatusOK || !quit {
+		t.Errorf("DELETE /cluster is %d and quit %t", w.Code, quit)
+	}
+}
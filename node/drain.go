@@ -0,0 +1,82 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mmcquillan/nomad-box/api"
+	"github.com/mmcquillan/nomad-box/config"
+	"github.com/mmcquillan/nomad-box/run"
+)
+
+// DrainNode drains a client and waits for it when asked, cancelling ctx stops the wait
+func DrainNode(ctx context.Context, cfg config.Config, nodes []Node, i int, deadline time.Duration, wait bool) error {
+
+	client, id, err := nomadNode(cfg, nodes, i)
+	if err != nil {
+		return err
+	}
+	run.Out("Draining " + nodes[i].Name)
+	if err := client.DrainNode(id, &api.DrainSpec{Deadline: deadline}); err != nil {
+		return err
+	}
+	if !wait {
+		return nil
+	}
+	run.Out("Waiting for " + nodes[i].Name + " to Drain")
+
+	// the drain strategy is cleared once every allocation has moved
+	return poll(ctx, time.Now().Add(deadline+time.Minute), func() error {
+		registered, err := client.Nodes()
+		if err != nil {
+			return err
+		}
+		for _, r := range registered {
+			if r.ID == id && r.Drain {
+				return errors.New(nodes[i].Name + " is still draining")
+			}
+		}
+		return nil
+	})
+}
+
+func CancelDrain(cfg config.Config, nodes []Node, i int) error {
+	client, id, err := nomadNode(cfg, nodes, i)
+	if err != nil {
+		return err
+	}
+	run.Out("Cancelling Drain of " + nodes[i].Name)
+	return client.DrainNode(id, nil)
+}
+
+func EligibilityNode(cfg config.Config, nodes []Node, i int, eligible bool) error {
+	client, id, err := nomadNode(cfg, nodes, i)
+	if err != nil {
+		return err
+	}
+	if eligible {
+		run.Out("Marking " + nodes[i].Name + " Eligible")
+	} else {
+		run.Out("Marking " + nodes[i].Name + " Ineligible")
+	}
+	return client.SetEligibility(id, eligible)
+}
+
+func nomadNode(cfg config.Config, nodes []Node, i int) (*api.Client, string, error) {
+	server := regionServer(nodes, nodes[i].Region, nodes[i].Name)
+	if server < 0 {
+		return nil, "", errors.New("no server in region " + nodes[i].Region)
+	}
+	client := apiClient(cfg, nodes[server])
+	registered, err := client.Nodes()
+	if err != nil {
+		return nil, "", err
+	}
+	for _, r := range registered {
+		if r.Name == nodes[i].Name {
+			return client, r.ID, nil
+		}
+	}
+	return nil, "", errors.New(nodes[i].Name + " is not registered with nomad")
+}
@@ -0,0 +1,31 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mmcquillan/nomad-box/api"
+)
+
+func TestDrainNodeCancelled(t *testing.T) {
+	fake := serveNomad(t, "127.0.0.1")
+	fake.nodes = []api.Node{{ID: "n1", Name: "nmdc0", Status: "ready", Drain: true}}
+	cfg, _ := testConfig(t)
+	nodes := readyNodes()
+
+	// the drain never finishes, cancelling stops the wait long before the deadline
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+	start := time.Now()
+	if err := DrainNode(ctx, cfg, nodes, 1, time.Hour, true); !errors.Is(err, context.Canceled) {
+		t.Errorf("error is %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("took %s to stop", time.Since(start))
+	}
+	if !contains(fake.calls, "/v1/node/n1/drain") {
+		t.Errorf("calls %v", fake.calls)
+	}
+}
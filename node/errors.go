@@ -0,0 +1,27 @@
+package node
+
+// phases of bringing up a cluster, each has its own exit code
+const (
+	PhaseNetwork = "network"
+	PhaseLaunch  = "launch"
+	PhaseReady   = "ready"
+)
+
+// PhaseError is a failure bringing up a cluster, on one node or all of them
+type PhaseError struct {
+	Phase string
+	Node  string
+	Err   error
+}
+
+func (e *PhaseError) Error() string {
+	msg := e.Phase + " failed"
+	if e.Node != "" {
+		msg += " on " + e.Node
+	}
+	return msg + ": " + e.Err.Error()
+}
+
+func (e *PhaseError) Unwrap() error {
+	return e.Err
+}
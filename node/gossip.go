@@ -0,0 +1,82 @@
+package node
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/mmcquillan/nomad-box/certs"
+	"github.com/mmcquillan/nomad-box/config"
+	"github.com/mmcquillan/nomad-box/run"
+)
+
+func RotateGossipKey(cfg config.Config, nodes []Node) (config.Config, error) {
+
+	run.Header("Rotating Gossip Key")
+	if cfg.GossipKey == "" {
+		return cfg, errors.New("cluster was not started with -gossip-encrypt")
+	}
+	server := regionServer(nodes, nodes[0].Region, "")
+	if server < 0 {
+		return cfg, errors.New("no server to rotate the gossip key")
+	}
+	client := apiClient(cfg, nodes[server])
+
+	// install the new key everywhere before switching to it
+	key, err := certs.GossipKey()
+	if err != nil {
+		return cfg, err
+	}
+	run.Out("Installing New Key")
+	if err := client.KeyringInstall(key); err != nil {
+		return cfg, err
+	}
+	run.Out("Using New Key")
+	if err := client.KeyringUse(key); err != nil {
+		return cfg, err
+	}
+	run.Out("Removing Old Key")
+	if err := client.KeyringRemove(cfg.GossipKey); err != nil {
+		return cfg, err
+	}
+
+	// restarted servers pick up the new key
+	cfg.GossipKey = key
+	return cfg, writeGossipConfig(cfg)
+}
+
+func ListGossipKeys(cfg config.Config, nodes []Node) error {
+
+	run.Header("Gossip Keys")
+	server := regionServer(nodes, nodes[0].Region, "")
+	if server < 0 {
+		return errors.New("no server to list the gossip keys")
+	}
+	keys, err := apiClient(cfg, nodes[server]).KeyringList()
+	if err != nil {
+		return err
+	}
+	for key, count := range keys {
+		primary := ""
+		if key == cfg.GossipKey {
+			primary = " (primary)"
+		}
+		run.Out(key + " on " + strconv.Itoa(count) + " members" + primary)
+	}
+	return nil
+}
+
+func writeGossipConfig(cfg config.Config) error {
+	config := []byte(`server {
+  encrypt = "` + cfg.GossipKey + `"
+}
+`)
+	return runner(cfg).WriteFile(gossipConfigFile(cfg), config, 0600)
+}
+
+func cleanGossip(cfg config.Config) {
+	run.Command(cfg.Runner, "rm -f "+gossipConfigFile(cfg))
+}
+
+func gossipConfigFile(cfg config.Config) string {
+	return cfg.Directory + "/gossip-config.hcl"
+}
@@ -0,0 +1,137 @@
+package node
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmcquillan/nomad-box/config"
+	"github.com/mmcquillan/nomad-box/run"
+)
+
+func NetemNodes(cfg config.Config, nodes []Node, targets []Node, netem config.Netem) {
+	run.Header("Shaping Nodes")
+	for _, target := range targets {
+		i, err := FindNode(cfg, nodes, target.Name)
+		if err != nil {
+			continue
+		}
+		previous := nodes[i].Netem
+		nodes[i].Netem = netem
+		printNode(nodes[i])
+		if err := ShapeNode(cfg, nodes[i]); err != nil {
+			nodes[i].Netem = previous
+			run.Error("Cannot Shape " + nodes[i].Name)
+			run.Error(err.Error())
+			continue
+		}
+		if err := CheckLatency(cfg, nodes, i); err != nil {
+			run.Error("Latency Not Seen on " + nodes[i].Name)
+			run.Error(err.Error())
+		}
+	}
+}
+
+func ShapeNode(cfg config.Config, node Node) error {
+
+	// leave the host device alone
+	if cfg.BindServer == node.Device {
+		run.Warn("Not shaping Bind Server device " + node.Device)
+		return nil
+	}
+
+	// no shaping removes the qdisc
+	if node.Netem == (config.Netem{}) {
+		if found, _ := run.CommandContains(cfg.Runner, nodeCommand(node, "tc qdisc show dev "+node.Device), "netem"); found {
+			return run.Command(cfg.Runner, nodeCommand(node, "tc qdisc del dev "+node.Device+" root"))
+		}
+		return nil
+	}
+
+	// host nodes reach each other over lo, their dummy device carries nothing
+	if node.Netns == "" {
+		return errors.New("netem needs -netns, nodes without a namespace talk over lo and cannot be shaped")
+	}
+
+	return run.Command(cfg.Runner, nodeCommand(node, "tc qdisc replace dev "+node.Device+" root "+netemArgs(node.Netem)))
+
+}
+
+var rttAvg = regexp.MustCompile(`= [0-9.]+/([0-9.]+)/`)
+
+// CheckLatency pings another node from a shaped node and fails unless the delay shows up
+func CheckLatency(cfg config.Config, nodes []Node, i int) error {
+
+	netem := nodes[i].Netem
+	if netem.Delay == "" {
+		return nil
+	}
+	want, err := time.ParseDuration(netem.Delay)
+	if err != nil {
+		return errors.New("cannot read delay " + netem.Delay + ": " + err.Error())
+	}
+	if netem.Jitter != "" {
+		jitter, err := time.ParseDuration(netem.Jitter)
+		if err != nil {
+			return errors.New("cannot read jitter " + netem.Jitter + ": " + err.Error())
+		}
+		want -= jitter
+	}
+
+	// any other node will do, the bridge when the node is alone
+	peer := BridgeIp(cfg)
+	for j := 0; j < len(nodes); j++ {
+		if j != i {
+			peer = nodes[j].Ip
+			break
+		}
+	}
+
+	// replies can be lost to shaping, the summary is there as long as one came back
+	out, _ := runner(cfg).Output(nodeCommand(nodes[i], "ping -c 3 -i 0.2 -W 2 "+peer))
+	match := rttAvg.FindStringSubmatch(out)
+	if match == nil {
+		return errors.New("no reply from " + peer)
+	}
+	avg, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return err
+	}
+	got := time.Duration(avg * float64(time.Millisecond))
+	if got < want {
+		return errors.New("round trip to " + peer + " is " + got.String() + ", want at least " + want.String())
+	}
+	return nil
+
+}
+
+func netemArgs(netem config.Netem) string {
+	args := []string{"netem"}
+	if netem.Delay != "" {
+		args = append(args, "delay", netem.Delay)
+		if netem.Jitter != "" {
+			args = append(args, netem.Jitter)
+		}
+	}
+	if netem.Loss != "" {
+		args = append(args, "loss", netem.Loss)
+	}
+	return strings.Join(args, " ")
+}
+
+func netemString(netem config.Netem) string {
+	var parts []string
+	if netem.Delay != "" {
+		d := netem.Delay
+		if netem.Jitter != "" {
+			d += "±" + netem.Jitter
+		}
+		parts = append(parts, d)
+	}
+	if netem.Loss != "" {
+		parts = append(parts, "loss:"+netem.Loss)
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,111 @@
+package node
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/mmcquillan/nomad-box/config"
+	"github.com/mmcquillan/nomad-box/run"
+)
+
+func TestShapeNodeHost(t *testing.T) {
+	cfg, recorder := testConfig(t)
+	nodes := MakeNodes(cfg)
+	nodes[1].Netem = config.Netem{Delay: "80ms"}
+
+	// the dummy device carries nothing, shaping it would only look like it worked
+	if err := ShapeNode(cfg, nodes[1]); err == nil || !strings.Contains(err.Error(), "-netns") {
+		t.Errorf("error is %v, want one asking for -netns", err)
+	}
+	if len(recorder.Commands) != 0 {
+		t.Errorf("ran %v", recorder.Commands)
+	}
+
+	// and the node keeps what it had
+	NetemNodes(cfg, nodes, nodes[1:], config.Netem{Delay: "20ms"})
+	if nodes[1].Netem.Delay != "80ms" {
+		t.Errorf("netem is %+v after a failed shaping", nodes[1].Netem)
+	}
+}
+
+func TestBuildNodesLatency(t *testing.T) {
+	cases := []struct {
+		name string
+		ping string
+		err  string
+	}{
+		{name: "seen", ping: "rtt min/avg/max/mdev = 80.112/80.456/80.901/0.321 ms"},
+		{name: "not seen", ping: "rtt min/avg/max/mdev = 0.041/0.052/0.070/0.011 ms", err: "want at least 70ms"},
+		{name: "no reply", err: "no reply from 10.10.10.1"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg, recorder := testConfig(t)
+			cfg.Netns = true
+			cfg.Groups[1].Netem = config.Netem{Delay: "80ms", Jitter: "10ms"}
+			nodes := MakeNodes(cfg)
+			recorder.Outputs = map[string]string{
+				"ip netns exec " + nodes[1].Netns + " ping -c 3 -i 0.2 -W 2 10.10.10.1": c.ping,
+			}
+
+			err := BuildNodes(cfg, nodes)
+			if c.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			var phase *PhaseError
+			if !errors.As(err, &phase) || phase.Phase != PhaseNetwork || phase.Node != nodes[1].Name || !strings.Contains(err.Error(), c.err) {
+				t.Fatalf("error is %v, want a network error for %s with %q", err, nodes[1].Name, c.err)
+			}
+		})
+	}
+}
+
+// TestLatencyShowsUp shapes a real namespace and measures it from another
+func TestLatencyShowsUp(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("needs root")
+	}
+	for _, tool := range []string{"ip", "tc", "ping"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skip(tool + " is not installed")
+		}
+	}
+	cfg, _ := testConfig(t)
+	cfg.Runner = run.Shell{}
+	cfg.Prefix = "nbt"
+	cfg.Netns = true
+	cfg.Ips = []string{"10.213.0.1", "10.213.0.2", "10.213.0.254"}
+	nodes := MakeNodes(cfg)
+
+	if err := makeBridge(cfg); err != nil {
+		t.Skip("cannot make a bridge: " + err.Error())
+	}
+	t.Cleanup(func() {
+		for _, node := range nodes {
+			cleanNodeResourcesNetns(cfg, node)
+		}
+		cleanBridge(cfg)
+	})
+	for _, node := range nodes {
+		if err := makeNodeResourcesNetns(cfg, node); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	nodes[1].Netem = config.Netem{Delay: "50ms"}
+	if err := ShapeNode(cfg, nodes[1]); err != nil {
+		if strings.Contains(err.Error(), "unknown") {
+			t.Skip("kernel has no netem")
+		}
+		t.Fatal(err)
+	}
+	if err := CheckLatency(cfg, nodes, 1); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,100 @@
+package node
+
+import (
+	"strings"
+
+	"github.com/mmcquillan/nomad-box/config"
+	"github.com/mmcquillan/nomad-box/network"
+	"github.com/mmcquillan/nomad-box/run"
+)
+
+func BridgeName(cfg config.Config) string {
+	return cfg.Prefix + "br0"
+}
+
+func BridgeIp(cfg config.Config) string {
+	return cfg.Ips[len(cfg.Ips)-1]
+}
+
+func makeBridge(cfg config.Config) error {
+
+	// bridge already there
+	if found, _ := run.CommandContains(cfg.Runner, "ip link show", BridgeName(cfg)+":"); found {
+		return nil
+	}
+
+	return runCommands(cfg,
+		// setup bridge device
+		"ip link add "+BridgeName(cfg)+" type bridge",
+		// set IP address so the host can reach the nodes
+		"ip addr add "+BridgeIp(cfg)+"/24 brd + dev "+BridgeName(cfg),
+		// bring up device
+		"ip link set dev "+BridgeName(cfg)+" up",
+	)
+
+}
+
+func cleanBridge(cfg config.Config) {
+	if found, _ := run.CommandContains(cfg.Runner, "ip link show", BridgeName(cfg)+":"); found {
+		run.Command(cfg.Runner, "ip link delete "+BridgeName(cfg)+" type bridge")
+	}
+}
+
+// netnsExists matches the namespace name exactly, ip netns list adds ids after it
+func netnsExists(cfg config.Config, name string) bool {
+	out, err := runner(cfg).Output("ip netns list")
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 && fields[0] == name {
+			return true
+		}
+	}
+	return false
+}
+
+func makeNodeResourcesNetns(cfg config.Config, node Node) error {
+	mac, err := network.IpMac(node.Ip)
+	if err != nil {
+		return err
+	}
+	return runCommands(cfg,
+		// setup namespace
+		"ip netns add "+node.Netns,
+		nodeCommand(node, "ip link set dev lo up"),
+		// veth pair with the node end moved into the namespace
+		"ip link add "+node.Device+" type veth peer name "+vethPeer(node),
+		"ip link set dev "+node.Device+" netns "+node.Netns,
+		// attach the host end to the bridge
+		"ip link set dev "+vethPeer(node)+" master "+BridgeName(cfg),
+		"ip link set dev "+vethPeer(node)+" up",
+		// set mac address
+		nodeCommand(node, "ip link set dev "+node.Device+" address "+mac),
+		// set IP address
+		nodeCommand(node, "ip addr add "+node.Ip+"/24 brd + dev "+node.Device),
+		// bring up device
+		nodeCommand(node, "ip link set dev "+node.Device+" up"),
+	)
+}
+
+func cleanNodeResourcesNetns(cfg config.Config, node Node) error {
+
+	// deleting the namespace takes the veth pair with it
+	if !netnsExists(cfg, node.Netns) {
+		return nil
+	}
+	return run.Command(cfg.Runner, "ip netns delete "+node.Netns)
+
+}
+
+func nodeCommand(node Node, command string) string {
+	if node.Netns != "" {
+		return "ip netns exec " + node.Netns + " " + command
+	}
+	return command
+}
+
+func vethPeer(node Node) string {
+	return node.Device + "p"
+}
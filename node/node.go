@@ -1,30 +1,43 @@
 package node
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/mmcquillan/nomad-box/certs"
 	"github.com/mmcquillan/nomad-box/config"
 	"github.com/mmcquillan/nomad-box/network"
 	"github.com/mmcquillan/nomad-box/run"
 )
 
 type Node struct {
-	Server bool
-	Binary string
-	Name   string
-	Region string
-	Dc     string
-	Pool   string
-	Ip     string
-	Device string
-	Dir    string
-	Config string
-	Params string
-	Pid    int `json:"-"`
+	Server      bool
+	Binary      string
+	Version     string
+	Name        string
+	Index       int
+	Group       int
+	Region      string
+	Dc          string
+	Pool        string
+	Ip          string
+	Device      string
+	Netns       string
+	Dir         string
+	Config      string
+	Params      string
+	Meta        map[string]string
+	NodeClass   string
+	HostVolumes []config.HostVolume
+	Netem       config.Netem
+	Restart     config.Restart
+	Pid         int
+	Started     time.Time
+	Restarts    int
+	ExitCode    int
 }
 
 func MakeNodes(cfg config.Config) (nodes []Node) {
@@ -32,179 +45,333 @@ func MakeNodes(cfg config.Config) (nodes []Node) {
 	// node slice
 	nodes = make([]Node, cfg.Servers+cfg.Clients)
 
-	// import
-	if cfg.Import {
-		nodes = importNodes()
-		if len(nodes) > 0 {
-			run.Header("Importing Nodes")
-			for i := 0; i < len(nodes); i++ {
-				printNode(nodes[i])
-			}
-			return nodes
-		}
-	}
-
-	// node marker
+	// node markers
 	marker := 0
+	s := 0
+	c := 0
 
 	// start feedback
 	run.Header("Mapping Nodes")
 
 	// make servers
-	for s := 0; s < cfg.Servers; s++ {
-		nodes[marker].Server = true
-		nodes[marker].Binary = cfg.Binary
-		nodes[marker].Name = cfg.Prefix + cfg.ServerPrefix + strconv.Itoa(s)
-		nodes[marker].Region = "global"
-		nodes[marker].Dc = "dc1"
-		nodes[marker].Pool = "default"
-		nodes[marker].Ip = cfg.Ips[marker]
-		nodes[marker].Device = cfg.Prefix + "eth" + strconv.Itoa(marker)
-		if s == 0 && cfg.BindServer != "" {
-			nodes[marker].Device = cfg.BindServer
-		}
-		nodes[marker].Dir = cfg.Directory + "/" + nodes[marker].Name
-		if cfg.ServerConfig != "" {
-			nodes[marker].Config = cfg.ServerConfig
-		}
-		if cfg.ServerParams != "" {
-			nodes[marker].Params = cfg.ServerParams
-		}
-		nodes[marker].Pid = 0
-		printNode(nodes[marker])
-		marker++
+	for j, g := range cfg.Groups {
+		if !g.Server {
+			continue
+		}
+		for n := 0; n < g.Count; n++ {
+			nodes[marker].Server = true
+			nodes[marker].Group = j
+			nodes[marker].Binary = groupValue(g.Binary, cfg.Binary)
+			nodes[marker].Name = cfg.Prefix + cfg.ServerPrefix + strconv.Itoa(s)
+			nodes[marker].Index = s
+			nodes[marker].Binary = nodeBinary(cfg, nodes[marker])
+			nodes[marker].Version = cfg.Versions[nodes[marker].Binary]
+			nodes[marker].Region = g.Region
+			nodes[marker].Dc = g.Dc
+			nodes[marker].Pool = g.Pool
+			nodes[marker].Ip = cfg.Ips[marker]
+			nodes[marker].Device = cfg.Prefix + "eth" + strconv.Itoa(marker)
+			if s == 0 && cfg.BindServer != "" {
+				nodes[marker].Device = cfg.BindServer
+			}
+			if cfg.Netns {
+				nodes[marker].Netns = nodes[marker].Name
+			}
+			nodes[marker].Dir = cfg.Directory + "/" + nodes[marker].Name
+			nodes[marker].Config = groupValue(g.Config, cfg.ServerConfig)
+			nodes[marker].Params = groupValue(g.Params, cfg.ServerParams)
+			nodes[marker].Netem = g.Netem
+			nodes[marker].Restart = groupRestart(g.Restart, cfg.Restart)
+			nodes[marker].Pid = 0
+			printNode(nodes[marker])
+			marker++
+			s++
+		}
 	}
 
 	// make clients
-	for c := 0; c < cfg.Clients; c++ {
-		nodes[marker].Server = false
-		nodes[marker].Binary = cfg.Binary
-		nodes[marker].Name = cfg.Prefix + cfg.ClientPrefix + strconv.Itoa(c)
-		nodes[marker].Region = "global"
-		nodes[marker].Dc = "dc1"
-		nodes[marker].Pool = "default"
-		nodes[marker].Ip = cfg.Ips[marker]
-		nodes[marker].Device = cfg.Prefix + "eth" + strconv.Itoa(marker)
-		nodes[marker].Pid = 0
-		nodes[marker].Dir = cfg.Directory + "/" + nodes[marker].Name
-		if cfg.ClientConfig != "" {
-			nodes[marker].Config = cfg.ClientConfig
+	for j, g := range cfg.Groups {
+		if g.Server {
+			continue
 		}
-		if cfg.ClientParams != "" {
-			nodes[marker].Params = cfg.ClientParams
+		for n := 0; n < g.Count; n++ {
+			nodes[marker] = clientNode(cfg, j, c, marker)
+			printNode(nodes[marker])
+			marker++
+			c++
 		}
-		printNode(nodes[marker])
-		marker++
-	}
-
-	// export
-	if cfg.Export {
-		exportNodes(nodes)
 	}
 
 	return nodes
 }
 
-func BuildNodes(cfg config.Config, nodes []Node) {
+func clientNode(cfg config.Config, group int, c int, marker int) (node Node) {
+	g := cfg.Groups[group]
+	node.Server = false
+	node.Group = group
+	node.Binary = groupValue(g.Binary, cfg.Binary)
+	node.Name = cfg.Prefix + cfg.ClientPrefix + strconv.Itoa(c)
+	node.Index = c
+	node.Binary = nodeBinary(cfg, node)
+	node.Version = cfg.Versions[node.Binary]
+	node.Region = g.Region
+	node.Dc = g.Dc
+	node.Pool = g.Pool
+	node.Ip = cfg.Ips[marker]
+	node.Device = cfg.Prefix + "eth" + strconv.Itoa(marker)
+	if cfg.Netns {
+		node.Netns = node.Name
+	}
+	node.Pid = 0
+	node.Dir = cfg.Directory + "/" + node.Name
+	node.Config = groupValue(g.Config, cfg.ClientConfig)
+	node.Params = groupValue(g.Params, cfg.ClientParams)
+	node.Meta = g.Meta
+	node.NodeClass = g.NodeClass
+	node.HostVolumes = g.HostVolumes
+	node.Netem = g.Netem
+	node.Restart = groupRestart(g.Restart, cfg.Restart)
+	return node
+}
+
+// BuildNodes makes and starts the nodes, rolling back what it made when one fails
+func BuildNodes(cfg config.Config, nodes []Node) error {
 
 	run.Header("Building Nodes")
 
+	built, err := buildNodes(cfg, nodes)
+	if err != nil {
+		run.Warn("Rolling back " + strconv.Itoa(built) + " nodes")
+		if clean := CleanNodes(cfg, nodes[:built]); clean != nil {
+			run.Error("Rollback Incomplete")
+			run.Error(clean.Error())
+		}
+	}
+	return err
+
+}
+
+func buildNodes(cfg config.Config, nodes []Node) (built int, err error) {
+
+	ca, err := buildShared(cfg)
+	if err != nil {
+		return 0, err
+	}
+
 	// check the nodes
 	for i := 0; i < len(nodes); i++ {
-
 		printNode(nodes[i])
+		if err := buildNode(cfg, ca, nodes, i); err != nil {
+			return i + 1, err
+		}
+	}
 
-		// node networking and directory space
-		makeNodeResources(cfg, nodes[i])
-
-		// run nomad process
-		if nodes[i].Server {
-
-			// run server nomad process
-			nomad := nodes[i].Binary + " agent "
-			nomad += " -node=" + nodes[i].Name
-			nomad += " -bind=" + nodes[i].Ip
-			nomad += " -bootstrap-expect=" + strconv.Itoa(cfg.Servers)
-			nomad += " -data-dir=" + nodes[i].Dir
-			nomad += " -dc=" + nodes[i].Dc
-			if nodes[i].Config != "" {
-				nomad += " -config=" + nodes[i].Config
-			}
-			if cfg.UI {
-				nomad += " -config=" + cfg.Directory + "/ui-config.hcl"
-			}
-			for j := 0; j < len(nodes); j++ {
-				if nodes[j].Server {
-					nomad += " -join=" + nodes[j].Ip
-				}
-			}
-			if cfg.Log {
-				nomad += " -log-level=" + cfg.LogLevel
-			}
-			nomad += " -network-interface=" + nodes[i].Device
-			nomad += " -region=" + nodes[i].Region
-			nomad += " -server"
-			if nodes[i].Params != "" {
-				nomad += " " + nodes[i].Params
-			}
-			nodes[i].Pid = run.Process(nomad, nodes[i].Name, cfg.Log)
-			time.Sleep(3 * time.Second)
-
-		} else {
-
-			// run client nomad process
-			nomad := nodes[i].Binary + " agent "
-			nomad += " -node=" + nodes[i].Name
-			nomad += " -bind=" + nodes[i].Ip
-			nomad += " -client"
-			nomad += " -data-dir=" + nodes[i].Dir
-			nomad += " -dc=" + nodes[i].Dc
-			nomad += " -node-pool=" + nodes[i].Pool
-			if nodes[i].Config != "" {
-				nomad += " -config=" + nodes[i].Config
-			}
-			if cfg.Log {
-				nomad += " -log-level=" + cfg.LogLevel
-			}
-			for j := 0; j < len(nodes); j++ {
-				if nodes[j].Server {
-					nomad += " -servers=" + nodes[j].Ip + ":4647"
-				}
-			}
-			nomad += " -network-interface=" + nodes[i].Device
-			nomad += " -region=" + nodes[i].Region
-			if nodes[i].Params != "" {
-				nomad += " " + nodes[i].Params
-			}
-			nodes[i].Pid = run.Process(nomad, nodes[i].Name, cfg.Log)
+	// shaped nodes must see their delay once every node is on the network
+	for i := 0; i < len(nodes); i++ {
+		if err := CheckLatency(cfg, nodes, i); err != nil {
+			return len(nodes), &PhaseError{Phase: PhaseNetwork, Node: nodes[i].Name, Err: err}
+		}
+	}
+
+	return len(nodes), nil
+}
 
+// buildShared makes what every node uses, the ca is empty without tls
+func buildShared(cfg config.Config) (ca certs.CA, err error) {
+
+	// working directory for the shared configs
+	if err := run.Command(cfg.Runner, "mkdir -p "+cfg.Directory); err != nil {
+		return ca, &PhaseError{Phase: PhaseLaunch, Err: err}
+	}
+
+	// shared bridge for namespaced nodes
+	if cfg.Netns {
+		if err := makeBridge(cfg); err != nil {
+			return ca, &PhaseError{Phase: PhaseNetwork, Err: err}
+		}
+	}
+
+	// acl config shared by all agents
+	if cfg.ACL {
+		if err := writeACLConfig(cfg); err != nil {
+			return ca, &PhaseError{Phase: PhaseLaunch, Err: err}
+		}
+	}
+
+	// gossip key shared by all servers
+	if cfg.GossipKey != "" {
+		if err := writeGossipConfig(cfg); err != nil {
+			return ca, &PhaseError{Phase: PhaseLaunch, Err: err}
+		}
+	}
+
+	// certificate authority
+	if cfg.TLS {
+		if ca, err = makeCA(cfg); err != nil {
+			return ca, &PhaseError{Phase: PhaseLaunch, Err: err}
+		}
+	}
+
+	return ca, nil
+}
+
+func buildNode(cfg config.Config, ca certs.CA, nodes []Node, i int) error {
+
+	// node networking and directory space
+	if err := makeNodeResources(cfg, nodes[i]); err != nil {
+		return err
+	}
+
+	// node certificates
+	if cfg.TLS {
+		if err := makeNodeTLS(cfg, ca, nodes[i]); err != nil {
+			return &PhaseError{Phase: PhaseLaunch, Node: nodes[i].Name, Err: err}
 		}
+	}
 
+	// run nomad process
+	if err := startNode(cfg, nodes, i); err != nil {
+		return &PhaseError{Phase: PhaseLaunch, Node: nodes[i].Name, Err: err}
 	}
 
-	run.Out("export NOMAD_ADDR=\"http://" + nodes[1].Ip + ":4646")
+	return nil
+}
+
+func agentCommand(cfg config.Config, nodes []Node, i int) string {
+	return strings.Join(agentArgs(cfg, nodes, i), " ")
+}
+
+func agentArgs(cfg config.Config, nodes []Node, i int) (args []string) {
+
+	if nodes[i].Server {
+
+		// run server nomad process
+		args = append(args, nodes[i].Binary, "agent")
+		args = append(args, "-node="+nodes[i].Name)
+		args = append(args, "-bind="+nodes[i].Ip)
+		args = append(args, "-bootstrap-expect="+strconv.Itoa(regionServers(nodes, nodes[i].Region)))
+		args = append(args, "-data-dir="+nodes[i].Dir)
+		args = append(args, "-dc="+nodes[i].Dc)
+		if nodes[i].Config != "" {
+			args = append(args, "-config="+nodeConfigPath(nodes[i]))
+		}
+		if cfg.UI {
+			args = append(args, "-config="+cfg.Directory+"/ui-config.hcl")
+		}
+		if cfg.TLS {
+			args = append(args, "-config="+tlsConfigFile(cfg, nodes[i]))
+		}
+		if cfg.ACL {
+			args = append(args, "-config="+aclConfigFile(cfg))
+		}
+		if cfg.GossipKey != "" {
+			args = append(args, "-config="+gossipConfigFile(cfg))
+		}
+		// servers from every region share the gossip pool so regions federate
+		for j := 0; j < len(nodes); j++ {
+			if nodes[j].Server {
+				args = append(args, "-join="+nodes[j].Ip)
+			}
+		}
+		if cfg.Log {
+			args = append(args, "-log-level="+cfg.LogLevel)
+		}
+		args = append(args, "-network-interface="+nodes[i].Device)
+		args = append(args, "-region="+nodes[i].Region)
+		args = append(args, "-server")
+		return append(args, strings.Fields(nodes[i].Params)...)
+
+	}
+
+	// run client nomad process
+	args = append(args, nodes[i].Binary, "agent")
+	args = append(args, "-node="+nodes[i].Name)
+	args = append(args, "-bind="+nodes[i].Ip)
+	args = append(args, "-client")
+	args = append(args, "-data-dir="+nodes[i].Dir)
+	args = append(args, "-dc="+nodes[i].Dc)
+	args = append(args, "-node-pool="+nodes[i].Pool)
+	if hasClientConfig(nodes[i]) {
+		args = append(args, "-config="+clientConfigFile(nodes[i]))
+	}
+	if nodes[i].Config != "" {
+		args = append(args, "-config="+nodeConfigPath(nodes[i]))
+	}
+	if cfg.TLS {
+		args = append(args, "-config="+tlsConfigFile(cfg, nodes[i]))
+	}
+	if cfg.ACL {
+		args = append(args, "-config="+aclConfigFile(cfg))
+	}
+	if cfg.Log {
+		args = append(args, "-log-level="+cfg.LogLevel)
+	}
+	for j := 0; j < len(nodes); j++ {
+		if nodes[j].Server && nodes[j].Region == nodes[i].Region {
+			args = append(args, "-servers="+nodes[j].Ip+":4647")
+		}
+	}
+	args = append(args, "-network-interface="+nodes[i].Device)
+	args = append(args, "-region="+nodes[i].Region)
+	return append(args, strings.Fields(nodes[i].Params)...)
 
 }
 
-func CleanNodes(cfg config.Config, nodes []Node) {
+// CleanNodes stops the nodes and removes what they used, carrying on past failures and returning them
+func CleanNodes(cfg config.Config, nodes []Node) error {
+	HealNodes(cfg, nodes)
 	run.Header("Cleaning Nodes")
-	for i := 0; i < len(nodes); i++ {
-		if !nodes[i].Server {
-			printNode(nodes[i])
-			cleanNodeProcess(cfg, nodes[i])
+	var errs []error
+	for _, servers := range []bool{false, true} {
+		for i := 0; i < len(nodes); i++ {
+			if nodes[i].Server != servers {
+				continue
+			}
+			node := stopNode(nodes, i)
+			printNode(node)
+			cleanNodeProcess(cfg, node)
 			if !cfg.Persist {
-				cleanNodeResources(cfg, nodes[i])
+				if err := cleanNodeResources(cfg, node); err != nil {
+					errs = append(errs, errors.New(node.Name+": "+err.Error()))
+				}
 			}
 		}
 	}
+	if cfg.Netns && !cfg.Persist {
+		cleanBridge(cfg)
+	}
+	if cfg.TLS && !cfg.Persist {
+		cleanTLS(cfg)
+	}
+	if cfg.ACL && !cfg.Persist {
+		cleanACL(cfg)
+	}
+	if cfg.GossipKey != "" && !cfg.Persist {
+		cleanGossip(cfg)
+	}
+	if err := RemoveState(cfg); err != nil {
+		errs = append(errs, errors.New("cannot remove state: "+err.Error()))
+	}
+	return errors.Join(errs...)
+}
+
+func StatusNodes(cfg config.Config, nodes []Node) {
+	run.Header("Node Status")
 	for i := 0; i < len(nodes); i++ {
-		if nodes[i].Server {
-			printNode(nodes[i])
-			cleanNodeProcess(cfg, nodes[i])
-			if !cfg.Persist {
-				cleanNodeResources(cfg, nodes[i])
-			}
+		status := "stopped exit=" + strconv.Itoa(nodes[i].ExitCode)
+		if nodes[i].Pid > 0 && run.CheckProcess(nodes[i].Pid) {
+			status = "running pid=" + strconv.Itoa(nodes[i].Pid) + " up=" + time.Since(nodes[i].Started).Round(time.Second).String()
+		}
+		if nodes[i].Restarts > 0 {
+			status += " restarts=" + strconv.Itoa(nodes[i].Restarts) + " last-exit=" + strconv.Itoa(nodes[i].ExitCode)
+		}
+		if nodes[i].Version != "" {
+			status += " version=" + nodes[i].Version
 		}
+		if nodes[i].Netem != (config.Netem{}) {
+			status += " netem=" + netemString(nodes[i].Netem)
+		}
+		run.Out(fmt.Sprintf("%s.%s.%s [ %s : %s ] %s", nodes[i].Region, nodes[i].Dc, nodes[i].Name, nodes[i].Ip, nodes[i].Device, status))
 	}
 }
 
@@ -212,24 +379,68 @@ func CleanNodeResources(cfg config.Config, nodes []Node) {
 	run.Header("Cleaning Node Resources")
 	for i := 0; i < len(nodes); i++ {
 		printNode(nodes[i])
-		cleanNodeResources(cfg, nodes[i])
+		if err := cleanNodeResources(cfg, nodes[i]); err != nil {
+			run.Error(err.Error())
+		}
+	}
+	if cfg.Netns {
+		cleanBridge(cfg)
+	}
+	if cfg.TLS {
+		cleanTLS(cfg)
+	}
+	if cfg.ACL {
+		cleanACL(cfg)
+	}
+	if cfg.GossipKey != "" {
+		cleanGossip(cfg)
 	}
 }
 
-func makeNodeResources(cfg config.Config, node Node) {
+func makeNodeResources(cfg config.Config, node Node) error {
 
 	// network check if exists
-	if run.CommandContains("ip a", node.Ip) {
+	var err error
+	if nodeNetworkExists(cfg, node) {
 		if !cfg.Persist {
 			cleanNodeResources(cfg, node)
-			makeNodeResourcesNetwork(cfg, node)
+			err = makeNodeResourcesNetwork(cfg, node)
 		}
 	} else {
-		makeNodeResourcesNetwork(cfg, node)
+		err = makeNodeResourcesNetwork(cfg, node)
+	}
+	if err != nil {
+		return &PhaseError{Phase: PhaseNetwork, Node: node.Name, Err: err}
+	}
+
+	// link shaping
+	if node.Netem != (config.Netem{}) {
+		if err := ShapeNode(cfg, node); err != nil {
+			return &PhaseError{Phase: PhaseNetwork, Node: node.Name, Err: err}
+		}
 	}
 
 	// make server directory
-	run.Command("mkdir -p " + node.Dir)
+	if err := run.Command(cfg.Runner, "mkdir -p "+node.Dir); err != nil {
+		return &PhaseError{Phase: PhaseLaunch, Node: node.Name, Err: err}
+	}
+
+	// client stanza and host volumes
+	if hasClientConfig(node) {
+		if err := makeHostVolumes(cfg, node); err != nil {
+			return &PhaseError{Phase: PhaseLaunch, Node: node.Name, Err: err}
+		}
+		if err := writeClientConfig(cfg, node); err != nil {
+			return &PhaseError{Phase: PhaseLaunch, Node: node.Name, Err: err}
+		}
+	}
+
+	// render the node config template
+	if node.Config != "" {
+		if err := renderNodeConfig(cfg, node); err != nil {
+			return &PhaseError{Phase: PhaseLaunch, Node: node.Name, Err: err}
+		}
+	}
 
 	// write ui config
 	if cfg.UI {
@@ -242,92 +453,185 @@ func makeNodeResources(cfg config.Config, node Node) {
   }
 }
 `)
-		err := os.WriteFile(cfg.Directory+"/ui-config.hcl", config, 0644)
-		if err != nil {
-			run.Error("Cannot Write UI Config")
-			run.Error(err.Error())
+		if err := runner(cfg).WriteFile(cfg.Directory+"/ui-config.hcl", config, 0644); err != nil {
+			return &PhaseError{Phase: PhaseLaunch, Node: node.Name, Err: err}
 		}
 	}
 
+	return nil
 }
 
-func makeNodeResourcesNetwork(cfg config.Config, node Node) {
+// runner carries out the commands and writes of a cluster, a Shell unless the config has one
+func runner(cfg config.Config) run.Runner {
+	return run.Or(cfg.Runner)
+}
 
-	if cfg.BindServer != node.Device {
+// links makes the devices of nodes outside namespaces, the configured backend unless the config has one
+func links(cfg config.Config) network.Backend {
+	if cfg.Links != nil {
+		return cfg.Links
+	}
+	return network.NewBackend(cfg.NetBackend, cfg.Runner)
+}
 
-		// setup network device
-		run.Command("ip link add " + node.Device + " type dummy")
+func nodeNetworkExists(cfg config.Config, node Node) bool {
+	if node.Netns != "" {
+		return netnsExists(cfg, node.Netns)
+	}
+	if found, _ := links(cfg).AddrExists(node.Ip); found {
+		return true
+	}
+	found, _ := links(cfg).LinkExists(node.Device)
+	return found
+}
 
-		// set mac address
-		run.Command("ip link set dev " + node.Device + " address " + network.GenerateMac())
+func makeNodeResourcesNetwork(cfg config.Config, node Node) error {
 
-		// set IP address
-		run.Command("ip addr add " + node.Ip + "/24 brd + dev " + node.Device + " label " + node.Device + ":0")
+	if node.Netns != "" {
+		return makeNodeResourcesNetns(cfg, node)
+	}
 
-		// bring up device
-		run.Command("ip link set dev " + node.Device + " up")
+	if cfg.BindServer == node.Device {
+		return nil
+	}
 
+	// setup network device
+	mac, err := network.IpMac(node.Ip)
+	if err != nil {
+		return err
 	}
+	backend := links(cfg)
+	if err := backend.AddDummy(node.Device); err != nil {
+		return err
+	}
+
+	// set mac address
+	if err := backend.SetMac(node.Device, mac); err != nil {
+		return err
+	}
+
+	// set IP address
+	if err := backend.AddAddr(node.Device, node.Ip+"/24", node.Device+":0"); err != nil {
+		return err
+	}
+
+	// bring up device
+	return backend.SetUp(node.Device)
 
 }
 
-func cleanNodeResources(cfg config.Config, node Node) {
+// cleanNodeResources removes the network and directory of a node, what is already gone is no failure
+func cleanNodeResources(cfg config.Config, node Node) error {
 
-	if cfg.BindServer != node.Device {
+	var errs []error
+	if node.Netns != "" {
+		if err := cleanNodeResourcesNetns(cfg, node); err != nil {
+			errs = append(errs, err)
+		}
+	} else if cfg.BindServer != node.Device {
 
 		// delete address from device
-		run.Command("ip addr del " + node.Ip + "/24 brd + dev " + node.Device + " label " + node.Device + ":0")
+		backend := links(cfg)
+		if err := backend.DelAddr(node.Device, node.Ip+"/24", node.Device+":0"); err != nil && !errors.Is(err, network.ErrNotFound) {
+			errs = append(errs, err)
+		}
 
 		// delete network device
-		run.Command("ip link delete " + node.Device + " type dummy")
+		if err := backend.DelLink(node.Device); err != nil && !errors.Is(err, network.ErrNotFound) {
+			errs = append(errs, err)
+		}
 
 	}
 
-	// delete server directory
-	time.Sleep(3 * time.Second)
-	run.Command("rm -rf " + node.Dir)
+	// delete server directory, once an agent that ran has let go of it
+	if node.Pid > 0 {
+		time.Sleep(3 * time.Second)
+	}
+	if err := run.Command(cfg.Runner, "rm -rf "+node.Dir); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
 
 }
 
 func cleanNodeProcess(cfg config.Config, node Node) {
 
+	// never started or already gone
+	if node.Pid <= 0 || !run.CheckProcess(node.Pid) {
+		return
+	}
+
 	// kill process
-	run.Command("kill -2 " + strconv.Itoa(node.Pid))
+	run.Command(cfg.Runner, "kill -2 "+strconv.Itoa(node.Pid))
 	for run.CheckProcess(node.Pid) {
 		time.Sleep(3 * time.Second)
 	}
 
 }
 
-func printNode(node Node) {
-	n := fmt.Sprintf("%s.%s.%s [ %s : %s : %s ]", node.Region, node.Dc, node.Name, node.Ip, node.Device, node.Dir)
-	run.Out(n)
+// runCommands runs commands in order, stopping at the first that fails
+func runCommands(cfg config.Config, commands ...string) error {
+	for _, command := range commands {
+		if err := run.Command(cfg.Runner, command); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func importNodes() (nodes []Node) {
-	mydir, _ := os.Getwd()
-	file, err := os.ReadFile(mydir + "/nodes.json")
-	if err != nil {
-		return nodes
+func LogFile(node Node) string {
+	return node.Dir + "/nomad.log"
+}
+
+func nodeBinary(cfg config.Config, node Node) string {
+	if binary, ok := cfg.Binaries[node.Name]; ok {
+		return binary
 	}
-	err = json.Unmarshal([]byte(file), &nodes)
-	if err != nil {
-		run.Error("Cannot Import Nodes")
-		run.Error(err.Error())
+	if binary, ok := cfg.Binaries[strings.TrimPrefix(node.Name, cfg.Prefix)]; ok {
+		return binary
 	}
-	return nodes
+	return node.Binary
 }
 
-func exportNodes(nodes []Node) {
-	mydir, _ := os.Getwd()
-	imp_json, err := json.MarshalIndent(nodes, "", "   ")
-	if err != nil {
-		run.Error("Cannot Export Nodes")
-		run.Error(err.Error())
+func groupValue(value string, fallback string) string {
+	if value != "" {
+		return value
 	}
-	err = os.WriteFile(mydir+"/nodes.json", imp_json, 0644)
-	if err != nil {
-		run.Error("Cannot Export Nodes")
-		run.Error(err.Error())
+	return fallback
+}
+
+func groupRestart(restart config.Restart, fallback config.Restart) config.Restart {
+	if restart.Policy != "" {
+		return restart
+	}
+	return fallback
+}
+
+func regionServers(nodes []Node, region string) (count int) {
+	for i := 0; i < len(nodes); i++ {
+		if nodes[i].Server && nodes[i].Region == region {
+			count++
+		}
 	}
+	return count
+}
+
+func printNode(node Node) {
+	n := fmt.Sprintf("%s.%s.%s [ %s : %s : %s ]", node.Region, node.Dc, node.Name, node.Ip, node.Device, node.Dir)
+	if node.Version != "" {
+		n += " version=" + node.Version
+	}
+	if node.Netns != "" {
+		n += " netns=" + node.Netns
+	}
+	if !node.Server {
+		n += " pool=" + node.Pool
+	}
+	if node.NodeClass != "" {
+		n += " class=" + node.NodeClass
+	}
+	if node.Netem != (config.Netem{}) {
+		n += " netem=" + netemString(node.Netem)
+	}
+	run.Out(n)
 }
@@ -0,0 +1,335 @@
+package node
+
+import (
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mmcquillan/nomad-box/config"
+	"github.com/mmcquillan/nomad-box/network"
+	"github.com/mmcquillan/nomad-box/run"
+)
+
+func TestMain(m *testing.M) {
+	run.Output = io.Discard
+	os.Exit(m.Run())
+}
+
+// testConfig is one server and one client that run nothing, the recorder sees every command and write
+func testConfig(t *testing.T) (config.Config, *run.Recorder) {
+	t.Helper()
+	cfg := config.Defaults()
+	cfg.Servers = 1
+	cfg.Clients = 1
+	cfg.Binary = "nomad"
+	cfg.Directory = "/tmp/nmd-test"
+	cfg.Detach = true
+	cfg.ReadyTimeout = 0
+	if err := config.Resolve(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Ips = []string{"10.10.10.1", "10.10.10.2", "10.10.10.3"}
+	recorder := &run.Recorder{}
+	cfg.Runner = recorder
+	cfg.Links = network.NewFake()
+	return cfg, recorder
+}
+
+func TestBuildNodesSequence(t *testing.T) {
+	cfg, recorder := testConfig(t)
+	nodes := MakeNodes(cfg)
+
+	if err := BuildNodes(cfg, nodes); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"mkdir -p /tmp/nmd-test",
+		"mkdir -p /tmp/nmd-test/nmds0",
+		"nomad agent -node=nmds0 -bind=10.10.10.1 -bootstrap-expect=1 -data-dir=/tmp/nmd-test/nmds0 -dc=dc1 -join=10.10.10.1 -network-interface=nmdeth0 -region=global -server",
+		"mkdir -p /tmp/nmd-test/nmdc0",
+		"nomad agent -node=nmdc0 -bind=10.10.10.2 -client -data-dir=/tmp/nmd-test/nmdc0 -dc=dc1 -node-pool=default -servers=10.10.10.1:4647 -network-interface=nmdeth1 -region=global",
+	}
+	if !reflect.DeepEqual(recorder.Commands, want) {
+		t.Errorf("commands:\n%s\nwant:\n%s", strings.Join(recorder.Commands, "\n"), strings.Join(want, "\n"))
+	}
+	if recorder.Steps[2].Log != "/tmp/nmd-test/nmds0/nomad.log" {
+		t.Errorf("server logs to %s", recorder.Steps[2].Log)
+	}
+}
+
+func TestBuildNodesNetnsSequence(t *testing.T) {
+	cfg, recorder := testConfig(t)
+	cfg.Netns = true
+	cfg.Clients = 0
+	cfg.Groups = cfg.Groups[:1]
+	nodes := MakeNodes(cfg)
+
+	if err := BuildNodes(cfg, nodes); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"mkdir -p /tmp/nmd-test",
+		"ip link show",
+		"ip link add nmdbr0 type bridge",
+		"ip addr add 10.10.10.3/24 brd + dev nmdbr0",
+		"ip link set dev nmdbr0 up",
+		"ip netns list",
+		"ip netns add nmds0",
+		"ip netns exec nmds0 ip link set dev lo up",
+		"ip link add nmdeth0 type veth peer name nmdeth0p",
+		"ip link set dev nmdeth0 netns nmds0",
+		"ip link set dev nmdeth0p master nmdbr0",
+		"ip link set dev nmdeth0p up",
+		"ip netns exec nmds0 ip link set dev nmdeth0 address 6E:6D:0A:0A:0A:01",
+		"ip netns exec nmds0 ip addr add 10.10.10.1/24 brd + dev nmdeth0",
+		"ip netns exec nmds0 ip link set dev nmdeth0 up",
+		"mkdir -p /tmp/nmd-test/nmds0",
+		"ip netns exec nmds0 nomad agent -node=nmds0 -bind=10.10.10.1 -bootstrap-expect=1 -data-dir=/tmp/nmd-test/nmds0 -dc=dc1 -join=10.10.10.1 -network-interface=nmdeth0 -region=global -server",
+	}
+	if !reflect.DeepEqual(recorder.Commands, want) {
+		t.Errorf("commands:\n%s\nwant:\n%s", strings.Join(recorder.Commands, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestPlanNodesKeepsRunners(t *testing.T) {
+	a, recorderA := testConfig(t)
+	b, recorderB := testConfig(t)
+	b.Directory = "/tmp/nmd-other"
+	b.Netns = true
+
+	done := make(chan error)
+	go func() {
+		_, err := PlanNodes(a, MakeNodes(a))
+		done <- err
+	}()
+	if err := BuildNodes(b, MakeNodes(b)); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// planning one cluster records nothing in either runner and building the other stays in its own
+	if len(recorderA.Commands) != 0 {
+		t.Errorf("plan ran %d commands on its config runner", len(recorderA.Commands))
+	}
+	for _, command := range recorderB.Commands {
+		if strings.Contains(command, "/tmp/nmd-test") {
+			t.Errorf("cluster b ran %s", command)
+		}
+	}
+}
+
+func TestBuildNodesFakeLinks(t *testing.T) {
+	cfg, _ := testConfig(t)
+	fake := network.NewFake()
+	cfg.Links = fake
+
+	// an address that only shares a prefix with a node must not stop it being made
+	fake.AddDummy("eth9")
+	fake.AddAddr("eth9", "10.10.10.12/24", "eth9")
+
+	if err := BuildNodes(cfg, MakeNodes(cfg)); err != nil {
+		t.Fatal(err)
+	}
+	for name, ip := range map[string]string{"nmdeth0": "10.10.10.1/24", "nmdeth1": "10.10.10.2/24"} {
+		link, ok := fake.Links[name]
+		if !ok {
+			t.Errorf("%s was not made", name)
+			continue
+		}
+		mac, _ := network.IpMac(strings.TrimSuffix(ip, "/24"))
+		if !link.Up || len(link.Addrs) != 1 || link.Addrs[0] != ip || link.Mac != mac {
+			t.Errorf("%s is %+v", name, *link)
+		}
+	}
+
+	// cleaning takes the devices away again
+	CleanNodeResources(cfg, MakeNodes(cfg))
+	if len(fake.Links) != 1 {
+		t.Errorf("%d links left after cleaning", len(fake.Links))
+	}
+}
+
+func TestPlanNodesBackend(t *testing.T) {
+	cfg, _ := testConfig(t)
+	cfg.Links = nil
+
+	plan, err := PlanNodes(cfg, MakeNodes(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Backend != "netlink" {
+		t.Errorf("planned backend %s", plan.Backend)
+	}
+	if step := plan.Nodes[0].Steps[2]; step.Kind != "netlink" || step.Command != "netlink link add nmdeth0 type dummy" {
+		t.Errorf("third step is %+v", step)
+	}
+
+	cfg.NetBackend = "ip"
+	plan, err = PlanNodes(cfg, MakeNodes(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if step := plan.Nodes[0].Steps[2]; plan.Backend != "ip" || step.Kind != "network" || step.Command != "ip link add nmdeth0 type dummy" {
+		t.Errorf("third step of %s is %+v", plan.Backend, step)
+	}
+}
+
+// failRunner records like a Recorder but fails to start the agent named fail
+type failRunner struct {
+	*run.Recorder
+	fail string
+}
+
+func (f failRunner) Daemon(command string, logFile string) (int, error) {
+	f.Recorder.Daemon(command, logFile)
+	if strings.Contains(command, "-node="+f.fail+" ") {
+		return 0, errors.New("cannot start " + f.fail)
+	}
+	return 0, nil
+}
+
+// failLinks fails to address the device named fail
+type failLinks struct {
+	*network.Fake
+	fail string
+}
+
+func (f failLinks) AddAddr(name string, cidr string, label string) error {
+	if name == f.fail {
+		return &network.LinkError{Op: "add addr", Link: name, Err: errors.New("no room")}
+	}
+	return f.Fake.AddAddr(name, cidr, label)
+}
+
+func TestBuildNodesRollback(t *testing.T) {
+	cases := []struct {
+		name  string
+		fail  func(cfg *config.Config, fake *network.Fake)
+		phase string
+	}{
+		{
+			name: "launch",
+			fail: func(cfg *config.Config, fake *network.Fake) {
+				cfg.Runner = failRunner{Recorder: cfg.Runner.(*run.Recorder), fail: "nmdc0"}
+			},
+			phase: PhaseLaunch,
+		},
+		{
+			name: "network",
+			fail: func(cfg *config.Config, fake *network.Fake) {
+				cfg.Links = failLinks{Fake: fake, fail: "nmdeth1"}
+			},
+			phase: PhaseNetwork,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg, recorder := testConfig(t)
+			cfg.Clients = 2
+			cfg.Groups[1].Count = 2
+			fake := network.NewFake()
+			cfg.Links = fake
+			c.fail(&cfg, fake)
+			nodes := MakeNodes(cfg)
+
+			// the second of three nodes fails
+			err := BuildNodes(cfg, nodes)
+			var phase *PhaseError
+			if !errors.As(err, &phase) || phase.Phase != c.phase || phase.Node != "nmdc0" {
+				t.Fatalf("error is %v, want a %s error for nmdc0", err, c.phase)
+			}
+
+			// the two nodes made so far are rolled back, the third was never touched
+			for _, dir := range []string{"/tmp/nmd-test/nmds0", "/tmp/nmd-test/nmdc0"} {
+				if !contains(recorder.Commands, "rm -rf "+dir) {
+					t.Errorf("%s was not removed", dir)
+				}
+			}
+			for _, command := range recorder.Commands {
+				if strings.Contains(command, "nmdc1") {
+					t.Errorf("nmdc1 was touched by %s", command)
+				}
+			}
+			if len(fake.Links) != 0 {
+				t.Errorf("%d links left after the rollback", len(fake.Links))
+			}
+			if !contains(recorder.Commands, "rm -f /tmp/nmd-test/state.json") {
+				t.Error("state was not removed")
+			}
+		})
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestGossipKeyFiles(t *testing.T) {
+	cfg, recorder := testConfig(t)
+	cfg.GossipKey = "Zm9vYmFyYmF6cXV4Zm9vYmFyYmF6cXV4Zm9vYmFyYmE="
+	nodes := MakeNodes(cfg)
+
+	// the key is only ever written for its owner
+	if err := SaveState(cfg, nodes); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeGossipConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range recorder.Steps {
+		if step.Path != "" && step.Mode != "-rw-------" {
+			t.Errorf("%s is written %s", step.Path, step.Mode)
+		}
+	}
+
+	// the key decides the cleanup whether or not gossip_encrypt was set
+	cfg.GossipEncrypt = false
+	CleanNodeResources(cfg, nodes)
+	if !contains(recorder.Commands, "rm -f "+gossipConfigFile(cfg)) {
+		t.Error("gossip config was not removed")
+	}
+}
+
+// stuckLinks cannot delete the device named fail
+type stuckLinks struct {
+	*network.Fake
+	fail string
+}
+
+func (s stuckLinks) DelLink(name string) error {
+	if name == s.fail {
+		return &network.LinkError{Op: "del link", Link: name, Err: errors.New("busy")}
+	}
+	return s.Fake.DelLink(name)
+}
+
+func TestCleanNodesErrors(t *testing.T) {
+	cfg, _ := testConfig(t)
+	fake := network.NewFake()
+	cfg.Links = stuckLinks{Fake: fake, fail: "nmdeth1"}
+	nodes := MakeNodes(cfg)
+	if err := BuildNodes(cfg, nodes); err != nil {
+		t.Fatal(err)
+	}
+
+	// the stuck device is reported, the rest is still cleaned
+	err := CleanNodes(cfg, nodes)
+	if err == nil || !strings.Contains(err.Error(), "nmdc0") {
+		t.Fatalf("error is %v, want one for nmdc0", err)
+	}
+	if _, ok := fake.Links["nmdeth0"]; ok {
+		t.Error("nmdeth0 was not removed")
+	}
+}
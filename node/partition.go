@@ -0,0 +1,117 @@
+package node
+
+import (
+	"os/exec"
+	"strings"
+
+	"github.com/mmcquillan/nomad-box/config"
+	"github.com/mmcquillan/nomad-box/run"
+)
+
+func PartitionNodes(cfg config.Config, between []Node, and []Node) error {
+
+	run.Header("Partitioning Nodes")
+	chain := partitionChain(cfg)
+
+	// drop traffic both ways between the sides
+	for _, a := range between {
+		for _, b := range and {
+			run.Out(a.Name + " <-/-> " + b.Name)
+			if a.Netns == "" {
+				err := makePartitionChain(cfg, Node{})
+				if err == nil {
+					err = runCommands(cfg,
+						"iptables -A "+chain+" -s "+a.Ip+" -d "+b.Ip+" -j DROP -m comment --comment "+chain,
+						"iptables -A "+chain+" -s "+b.Ip+" -d "+a.Ip+" -j DROP -m comment --comment "+chain,
+					)
+				}
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			// namespaced nodes talk over the bridge so each side drops the other
+			for _, pair := range [][2]Node{{a, b}, {b, a}} {
+				err := makePartitionChain(cfg, pair[0])
+				if err == nil {
+					err = runCommands(cfg,
+						nodeCommand(pair[0], "iptables -A "+chain+" -s "+pair[1].Ip+" -j DROP -m comment --comment "+chain),
+						nodeCommand(pair[0], "iptables -A "+chain+" -d "+pair[1].Ip+" -j DROP -m comment --comment "+chain),
+					)
+				}
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+
+}
+
+func HealNodes(cfg config.Config, nodes []Node) {
+
+	// nothing to heal
+	if _, err := exec.LookPath("iptables"); err != nil {
+		return
+	}
+
+	// the host and every namespace that still has the tagged chain, quiet when none do
+	var tagged []Node
+	if found, _ := hasPartitionChain(cfg, Node{}); found {
+		tagged = append(tagged, Node{})
+	}
+	for i := 0; i < len(nodes); i++ {
+		if nodes[i].Netns == "" || !netnsExists(cfg, nodes[i].Netns) {
+			continue
+		}
+		if found, _ := hasPartitionChain(cfg, nodes[i]); found {
+			tagged = append(tagged, nodes[i])
+		}
+	}
+	if len(tagged) == 0 {
+		return
+	}
+
+	run.Header("Healing Partitions")
+	for _, node := range tagged {
+		healPartitionChain(cfg, node)
+	}
+
+}
+
+func makePartitionChain(cfg config.Config, node Node) error {
+
+	chain := partitionChain(cfg)
+
+	// tagged chain hooked into input and output
+	found, err := hasPartitionChain(cfg, node)
+	if err != nil || found {
+		return err
+	}
+	return runCommands(cfg,
+		nodeCommand(node, "iptables -N "+chain),
+		nodeCommand(node, "iptables -I INPUT -j "+chain+" -m comment --comment "+chain),
+		nodeCommand(node, "iptables -I OUTPUT -j "+chain+" -m comment --comment "+chain),
+	)
+
+}
+
+func hasPartitionChain(cfg config.Config, node Node) (bool, error) {
+	return run.CommandContains(cfg.Runner, nodeCommand(node, "iptables -S"), "-N "+partitionChain(cfg))
+}
+
+func healPartitionChain(cfg config.Config, node Node) {
+
+	chain := partitionChain(cfg)
+	run.Command(cfg.Runner, nodeCommand(node, "iptables -D INPUT -j "+chain+" -m comment --comment "+chain))
+	run.Command(cfg.Runner, nodeCommand(node, "iptables -D OUTPUT -j "+chain+" -m comment --comment "+chain))
+	run.Command(cfg.Runner, nodeCommand(node, "iptables -F "+chain))
+	run.Command(cfg.Runner, nodeCommand(node, "iptables -X "+chain))
+
+}
+
+func partitionChain(cfg config.Config) string {
+	return strings.ToUpper(cfg.Prefix) + "-PARTITION"
+}
@@ -0,0 +1,70 @@
+package node
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mmcquillan/nomad-box/run"
+)
+
+func TestHealNodes(t *testing.T) {
+
+	// heal only looks iptables up, the recorder answers for it
+	bin := t.TempDir()
+	if err := os.WriteFile(bin+"/iptables", []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+":"+os.Getenv("PATH"))
+
+	cases := []struct {
+		name    string
+		outputs map[string]string
+		healed  []string
+	}{
+		{name: "no partition"},
+		{
+			name:    "host",
+			outputs: map[string]string{"iptables -S": "-P INPUT ACCEPT\n-N NMD-PARTITION\n"},
+			healed:  []string{"iptables -X NMD-PARTITION"},
+		},
+		{
+			name: "namespace",
+			outputs: map[string]string{
+				"ip netns list":                   "nmdc0 (id: 1)\nnmds0 (id: 0)\n",
+				"ip netns exec nmdc0 iptables -S": "-N NMD-PARTITION\n",
+				"ip netns exec nmds0 iptables -S": "-P INPUT ACCEPT\n",
+			},
+			healed: []string{"ip netns exec nmdc0 iptables -X NMD-PARTITION"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			run.Output = out
+			defer func() { run.Output = io.Discard }()
+			cfg, recorder := testConfig(t)
+			cfg.Netns = c.name == "namespace"
+			recorder.Outputs = c.outputs
+
+			HealNodes(cfg, MakeNodes(cfg))
+			removed := 0
+			for _, command := range recorder.Commands {
+				if strings.Contains(command, "iptables -X") {
+					removed++
+					if !contains(c.healed, command) {
+						t.Errorf("ran %s", command)
+					}
+				}
+			}
+			if removed != len(c.healed) {
+				t.Errorf("removed %d chains, want %d", removed, len(c.healed))
+			}
+			if healed := strings.Contains(out.String(), "Healing Partitions"); healed != (len(c.healed) > 0) {
+				t.Errorf("output is %q", out)
+			}
+		})
+	}
+}
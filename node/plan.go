@@ -0,0 +1,176 @@
+package node
+
+import (
+	"strings"
+
+	"github.com/mmcquillan/nomad-box/certs"
+	"github.com/mmcquillan/nomad-box/config"
+	"github.com/mmcquillan/nomad-box/network"
+	"github.com/mmcquillan/nomad-box/run"
+)
+
+// Plan is everything up would do, for review before running it
+type Plan struct {
+	Backend string     `json:"backend"`
+	Shared  []PlanStep `json:"shared"`
+	Nodes   []NodePlan `json:"nodes"`
+}
+
+type NodePlan struct {
+	Name   string     `json:"name"`
+	Server bool       `json:"server"`
+	Region string     `json:"region"`
+	Dc     string     `json:"dc"`
+	Ip     string     `json:"ip"`
+	Device string     `json:"device"`
+	Netns  string     `json:"netns,omitempty"`
+	Dir    string     `json:"dir"`
+	Steps  []PlanStep `json:"steps"`
+	Argv   []string   `json:"argv"`
+}
+
+// PlanStep is a command or file write, the kind is network, netlink, directory, file or agent
+type PlanStep struct {
+	Kind string `json:"kind"`
+	run.Step
+}
+
+// PlanNodes records what BuildNodes would run and write without doing any of it
+func PlanNodes(cfg config.Config, nodes []Node) (plan Plan, err error) {
+
+	// devices as the backend would make them, agents detached so nothing is supervised
+	recorder := &run.Recorder{}
+	cfg.Runner = recorder
+	plan.Backend = cfg.NetBackend
+	if cfg.NetBackend == "ip" {
+		cfg.Links = network.Ip{Runner: recorder}
+	} else {
+		plan.Backend = "netlink"
+		cfg.Links = planLinks{runner: recorder}
+	}
+	cfg.Detach = true
+
+	var ca certs.CA
+	ca, err = buildShared(cfg)
+	plan.Shared = planSteps(cfg, recorder.Steps)
+	if err != nil {
+		return plan, err
+	}
+
+	for i := 0; i < len(nodes); i++ {
+		start := len(recorder.Steps)
+		err = buildNode(cfg, ca, nodes, i)
+		plan.Nodes = append(plan.Nodes, NodePlan{
+			Name:   nodes[i].Name,
+			Server: nodes[i].Server,
+			Region: nodes[i].Region,
+			Dc:     nodes[i].Dc,
+			Ip:     nodes[i].Ip,
+			Device: nodes[i].Device,
+			Netns:  nodes[i].Netns,
+			Dir:    nodes[i].Dir,
+			Steps:  planSteps(cfg, recorder.Steps[start:]),
+			Argv:   agentArgs(cfg, nodes, i),
+		})
+		if err != nil {
+			return plan, err
+		}
+	}
+	return plan, nil
+}
+
+func PrintPlan(plan Plan) {
+	run.Header("Plan")
+	run.Out("network backend " + plan.Backend)
+	printSteps(plan.Shared)
+	for _, n := range plan.Nodes {
+		run.Header("Plan " + n.Name)
+		printSteps(n.Steps)
+		run.Out("argv")
+		for _, arg := range n.Argv {
+			run.Out("   " + arg)
+		}
+	}
+}
+
+func planSteps(cfg config.Config, steps []run.Step) (planned []PlanStep) {
+	for _, step := range steps {
+		kind := "network"
+		switch {
+		case step.Path != "":
+			kind = "file"
+			// keys are made fresh each run and the gossip key is a secret
+			if strings.HasSuffix(step.Path, ".pem") || step.Path == gossipConfigFile(cfg) {
+				step.Content = "<generated>"
+			}
+		case step.Log != "":
+			kind = "agent"
+		case strings.HasPrefix(step.Command, "mkdir "):
+			kind = "directory"
+		case strings.HasPrefix(step.Command, "netlink "):
+			kind = "netlink"
+		}
+		planned = append(planned, PlanStep{Kind: kind, Step: step})
+	}
+	return planned
+}
+
+func printSteps(steps []PlanStep) {
+	for _, step := range steps {
+		switch step.Kind {
+		case "file":
+			run.Out("write " + step.Path + " " + step.Mode)
+			for _, line := range strings.Split(strings.TrimRight(step.Content, "\n"), "\n") {
+				run.Out("   | " + line)
+			}
+		case "agent":
+			run.Out("$ " + step.Command + " >> " + step.Log)
+		case "netlink":
+			run.Out(step.Command)
+		default:
+			run.Out("$ " + step.Command)
+		}
+	}
+}
+
+// planLinks records what the netlink backend would ask the kernel for, nothing exists yet
+type planLinks struct {
+	runner run.Runner
+}
+
+func (p planLinks) step(op string) error {
+	_, err := p.runner.Output("netlink " + op)
+	return err
+}
+
+func (p planLinks) LinkExists(name string) (bool, error) {
+	return false, p.step("link get " + name)
+}
+
+func (p planLinks) AddrExists(ip string) (bool, error) {
+	return false, p.step("addr list " + ip)
+}
+
+func (p planLinks) AddDummy(name string) error {
+	return p.step("link add " + name + " type dummy")
+}
+
+func (p planLinks) SetMac(name string, mac string) error {
+	return p.step("link set " + name + " address " + mac)
+}
+
+func (p planLinks) AddAddr(name string, cidr string, label string) error {
+	return p.step("addr add " + cidr + " dev " + name + " label " + label)
+}
+
+func (p planLinks) SetUp(name string) error {
+	return p.step("link set " + name + " up")
+}
+
+func (p planLinks) DelAddr(name string, cidr string, label string) error {
+	return p.step("addr del " + cidr + " dev " + name + " label " + label)
+}
+
+func (p planLinks) DelLink(name string) error {
+	return p.step("link del " + name)
+}
@@ -0,0 +1,255 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mmcquillan/nomad-box/api"
+	"github.com/mmcquillan/nomad-box/config"
+	"github.com/mmcquillan/nomad-box/run"
+)
+
+func WaitNodes(ctx context.Context, cfg config.Config, nodes []Node) error {
+
+	// waiting disabled
+	if cfg.ReadyTimeout <= 0 {
+		return nil
+	}
+
+	run.Header("Waiting for Nodes")
+	deadline := time.Now().Add(cfg.ReadyTimeout)
+
+	for _, region := range regions(nodes) {
+
+		// pick a server in the region to ask
+		server := regionServer(nodes, region, "")
+		if server < 0 {
+			continue
+		}
+		client := apiClient(cfg, nodes[server])
+
+		// leader elected
+		run.Out("Waiting for Leader in " + region)
+		if err := poll(ctx, deadline, func() error { return leaderElected(client, region) }); err != nil {
+			return err
+		}
+
+		// servers alive in serf
+		run.Out("Waiting for Servers in " + region)
+		for i := 0; i < len(nodes); i++ {
+			if nodes[i].Server && nodes[i].Region == region {
+				if err := poll(ctx, deadline, func() error { return serverAlive(client, nodes[i], "") }); err != nil {
+					return err
+				}
+			}
+		}
+
+		// clients registered and ready
+		run.Out("Waiting for Clients in " + region)
+		for i := 0; i < len(nodes); i++ {
+			if !nodes[i].Server && nodes[i].Region == region {
+				if err := poll(ctx, deadline, func() error { return clientReady(client, nodes[i], "", 0) }); err != nil {
+					return err
+				}
+			}
+		}
+
+	}
+
+	run.Out("Cluster Ready")
+	return nil
+}
+
+// WaitNode waits for a started node to come back on its version, since is the modify index
+// a client had before it was restarted so an old registration does not count
+func WaitNode(ctx context.Context, cfg config.Config, nodes []Node, i int, since uint64) error {
+
+	// waiting disabled
+	if cfg.ReadyTimeout <= 0 {
+		return nil
+	}
+
+	// ask another server of the region when there is one
+	deadline := time.Now().Add(cfg.ReadyTimeout)
+	client, err := regionClient(cfg, nodes, i)
+	if err != nil {
+		return err
+	}
+
+	run.Out("Waiting for " + nodes[i].Name)
+	if nodes[i].Server {
+		if err := poll(ctx, deadline, func() error { return serverAlive(client, nodes[i], nodes[i].Version) }); err != nil {
+			return err
+		}
+		return poll(ctx, deadline, func() error { return leaderElected(client, nodes[i].Region) })
+	}
+	return poll(ctx, deadline, func() error { return clientReady(client, nodes[i], nodes[i].Version, since) })
+}
+
+// Registration is the modify index of a client as the servers have it, 0 for servers and unknown clients
+func Registration(cfg config.Config, nodes []Node, i int) uint64 {
+	if nodes[i].Server || cfg.ReadyTimeout <= 0 {
+		return 0
+	}
+	client, err := regionClient(cfg, nodes, i)
+	if err != nil {
+		return 0
+	}
+	registered, err := client.Nodes()
+	if err != nil {
+		return 0
+	}
+	for _, r := range registered {
+		if r.Name == nodes[i].Name {
+			return r.ModifyIndex
+		}
+	}
+	return 0
+}
+
+func regionClient(cfg config.Config, nodes []Node, i int) (*api.Client, error) {
+	server := regionServer(nodes, nodes[i].Region, nodes[i].Name)
+	if server < 0 {
+		server = regionServer(nodes, nodes[i].Region, "")
+	}
+	if server < 0 {
+		return nil, errors.New("no server in region " + nodes[i].Region)
+	}
+	return apiClient(cfg, nodes[server]), nil
+}
+
+func Addr(cfg config.Config, nodes []Node) string {
+	for i := 0; i < len(nodes); i++ {
+		if nodes[i].Server && nodes[i].Device != cfg.BindServer {
+			return NodeAddr(cfg, nodes[i])
+		}
+	}
+	for i := 0; i < len(nodes); i++ {
+		if nodes[i].Server {
+			return NodeAddr(cfg, nodes[i])
+		}
+	}
+	return ""
+}
+
+func NodeAddr(cfg config.Config, node Node) string {
+	if cfg.TLS {
+		return "https://" + node.Ip + ":4646"
+	}
+	return "http://" + node.Ip + ":4646"
+}
+
+func apiClient(cfg config.Config, node Node) *api.Client {
+	client := api.NewClient(NodeAddr(cfg, node))
+	if cfg.ACL {
+		if secret, err := os.ReadFile(ACLToken(cfg, "management")); err == nil {
+			client.Token = strings.TrimSpace(string(secret))
+		}
+	}
+	if cfg.TLS {
+		if err := client.SetTLS(TLSCert(cfg, "ca"), TLSCert(cfg, "cli"), TLSKey(cfg, "cli")); err != nil {
+			run.Error("Cannot Load TLS for API")
+			run.Error(err.Error())
+		}
+	}
+	return client
+}
+
+func leaderElected(client *api.Client, region string) error {
+	leader, err := client.Leader()
+	if err != nil {
+		return err
+	}
+	if leader == "" {
+		return errors.New("no leader in region " + region)
+	}
+	return nil
+}
+
+// serverAlive wants the server alive in serf, on the version when one is given
+func serverAlive(client *api.Client, node Node, version string) error {
+	members, err := client.Members()
+	if err != nil {
+		return err
+	}
+	for _, m := range members {
+		if m.Name == node.Name+"."+node.Region && m.Status == "alive" {
+			if !sameVersion(version, m.Tags["build"]) {
+				return errors.New("server " + node.Name + " is on " + m.Tags["build"] + " not " + version)
+			}
+			return nil
+		}
+	}
+	return errors.New("server " + node.Name + " is not alive")
+}
+
+// clientReady wants the client ready, on the version when one is given and registered after since
+func clientReady(client *api.Client, node Node, version string, since uint64) error {
+	registered, err := client.Nodes()
+	if err != nil {
+		return err
+	}
+	for _, r := range registered {
+		if r.Name == node.Name && r.Status == "ready" {
+			if since > 0 && r.ModifyIndex <= since {
+				return errors.New("client " + node.Name + " has not registered again")
+			}
+			if !sameVersion(version, r.Version) {
+				return errors.New("client " + node.Name + " is on " + r.Version + " not " + version)
+			}
+			return nil
+		}
+	}
+	return errors.New("client " + node.Name + " is not ready")
+}
+
+// sameVersion compares nomad version output like v1.6.0 with the 1.6.0 an agent reports
+func sameVersion(want string, got string) bool {
+	fields := strings.Fields(want)
+	if len(fields) == 0 {
+		return true
+	}
+	want = strings.TrimPrefix(fields[0], "v")
+	got = strings.TrimPrefix(got, "v")
+	return got == want || strings.HasPrefix(got, want+"+")
+}
+
+func regionServer(nodes []Node, region string, exclude string) int {
+	for i := 0; i < len(nodes); i++ {
+		if nodes[i].Server && nodes[i].Region == region && nodes[i].Name != exclude {
+			return i
+		}
+	}
+	return -1
+}
+
+func regions(nodes []Node) (regions []string) {
+	seen := make(map[string]bool)
+	for i := 0; i < len(nodes); i++ {
+		if !seen[nodes[i].Region] {
+			seen[nodes[i].Region] = true
+			regions = append(regions, nodes[i].Region)
+		}
+	}
+	return regions
+}
+
+func poll(ctx context.Context, deadline time.Time, check func() error) error {
+	for {
+		err := check()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New("timed out: " + strings.TrimSpace(err.Error()))
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
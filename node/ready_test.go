@@ -0,0 +1,234 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mmcquillan/nomad-box/api"
+	"github.com/mmcquillan/nomad-box/config"
+)
+
+// fakeNomad answers the status, members and nodes endpoints the way a nomad server does
+type fakeNomad struct {
+	mu      sync.Mutex
+	leader  string
+	members []api.Member
+	nodes   []api.Node
+	secret  string
+	calls   []string
+}
+
+func (f *fakeNomad) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, r.URL.Path)
+	var out interface{}
+	switch r.URL.Path {
+	case "/v1/status/leader":
+		out = f.leader
+	case "/v1/agent/members":
+		out = map[string]interface{}{"ServerName": "nmds0", "ServerRegion": "global", "Members": f.members}
+	case "/v1/nodes":
+		out = f.nodes
+	case "/v1/acl/bootstrap":
+		if f.secret == "" {
+			http.Error(w, "ACL bootstrap already done (reset index: 7)", http.StatusBadRequest)
+			return
+		}
+		out = api.ACLToken{Name: "Bootstrap Token", Type: "management", SecretID: f.secret}
+	default:
+		// drain, eligibility and purge of a node
+		if !strings.HasPrefix(r.URL.Path, "/v1/node/") {
+			http.NotFound(w, r)
+			return
+		}
+		out = map[string]string{}
+	}
+	json.NewEncoder(w).Encode(out)
+}
+
+// serveNomad listens where the nodes api is, the test is skipped when something else has the port
+func serveNomad(t *testing.T, ip string) *fakeNomad {
+	t.Helper()
+	listener, err := net.Listen("tcp", ip+":4646")
+	if err != nil {
+		t.Skip("cannot listen on " + ip + ":4646: " + err.Error())
+	}
+	fake := &fakeNomad{}
+	server := httptest.NewUnstartedServer(fake)
+	server.Listener.Close()
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+	return fake
+}
+
+func readyNodes() []Node {
+	return []Node{
+		{Name: "nmds0", Server: true, Region: "global", Ip: "127.0.0.1"},
+		{Name: "nmdc0", Region: "global", Ip: "127.0.0.2"},
+		{Name: "nmdc1", Region: "global", Ip: "127.0.0.3"},
+	}
+}
+
+func TestWaitNodesReady(t *testing.T) {
+	fake := serveNomad(t, "127.0.0.1")
+	fake.leader = "127.0.0.1:4647"
+	fake.members = []api.Member{{Name: "nmds0.global", Status: "alive"}}
+	fake.nodes = []api.Node{{Name: "nmdc0", Status: "ready"}, {Name: "nmdc1", Status: "ready"}}
+
+	cfg := config.Config{ReadyTimeout: 5 * time.Second}
+	if err := WaitNodes(context.Background(), cfg, readyNodes()); err != nil {
+		t.Fatal(err)
+	}
+
+	// leader first, then serf, then the clients
+	want := []string{"/v1/status/leader", "/v1/agent/members", "/v1/nodes", "/v1/nodes"}
+	if strings.Join(fake.calls, " ") != strings.Join(want, " ") {
+		t.Errorf("calls %v, want %v", fake.calls, want)
+	}
+}
+
+func TestWaitNodesNotReady(t *testing.T) {
+	cases := []struct {
+		name    string
+		leader  string
+		members []api.Member
+		nodes   []api.Node
+		reason  string
+	}{
+		{
+			name:   "no leader",
+			leader: "",
+			reason: "no leader in region global",
+		},
+		{
+			name:    "server without region",
+			leader:  "127.0.0.1:4647",
+			members: []api.Member{{Name: "nmds0", Status: "alive"}},
+			reason:  "server nmds0 is not alive",
+		},
+		{
+			name:    "server failed",
+			leader:  "127.0.0.1:4647",
+			members: []api.Member{{Name: "nmds0.global", Status: "failed"}},
+			reason:  "server nmds0 is not alive",
+		},
+		{
+			name:    "client initializing",
+			leader:  "127.0.0.1:4647",
+			members: []api.Member{{Name: "nmds0.global", Status: "alive"}},
+			nodes:   []api.Node{{Name: "nmdc0", Status: "ready"}, {Name: "nmdc1", Status: "initializing"}},
+			reason:  "client nmdc1 is not ready",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fake := serveNomad(t, "127.0.0.1")
+			fake.leader, fake.members, fake.nodes = c.leader, c.members, c.nodes
+
+			cfg := config.Config{ReadyTimeout: 100 * time.Millisecond}
+			err := WaitNodes(context.Background(), cfg, readyNodes())
+			if err == nil || !strings.Contains(err.Error(), "timed out") || !strings.Contains(err.Error(), c.reason) {
+				t.Errorf("error is %v, want a time out on %s", err, c.reason)
+			}
+		})
+	}
+}
+
+func TestWaitNodesCancelled(t *testing.T) {
+	serveNomad(t, "127.0.0.1")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cfg := config.Config{ReadyTimeout: time.Minute}
+	if err := WaitNodes(ctx, cfg, readyNodes()); !errors.Is(err, context.Canceled) {
+		t.Errorf("error is %v, want context.Canceled", err)
+	}
+}
+
+func TestStartNodesNotReady(t *testing.T) {
+	serveNomad(t, "127.0.0.1")
+	cfg, _ := testConfig(t)
+	cfg.Ips = []string{"127.0.0.1", "127.0.0.2"}
+	cfg.ReadyTimeout = 100 * time.Millisecond
+
+	// built and started, but never ready is a ready phase error so up exits with its own code
+	_, err := StartNodes(context.Background(), cfg, MakeNodes(cfg))
+	var phase *PhaseError
+	if !errors.As(err, &phase) || phase.Phase != PhaseReady {
+		t.Errorf("error is %v, want a ready phase error", err)
+	}
+}
+
+func TestWaitNodeVersion(t *testing.T) {
+	fake := serveNomad(t, "127.0.0.1")
+	fake.leader = "127.0.0.1:4647"
+	nodes := readyNodes()
+	nodes = append(nodes, Node{Name: "nmds1", Server: true, Region: "global", Ip: "127.0.0.4", Version: "v1.7.0"})
+	nodes[1].Version = "v1.7.0"
+	cfg := config.Config{ReadyTimeout: 100 * time.Millisecond}
+
+	// still the old agents as the servers last saw them
+	fake.members = []api.Member{{Name: "nmds1.global", Status: "alive", Tags: map[string]string{"build": "1.6.0"}}}
+	fake.nodes = []api.Node{{Name: "nmdc0", Status: "ready", Version: "1.6.0", ModifyIndex: 10}}
+	if err := WaitNode(context.Background(), cfg, nodes, 3, 0); err == nil || !strings.Contains(err.Error(), "is on 1.6.0") {
+		t.Errorf("server on the old build: %v", err)
+	}
+	if err := WaitNode(context.Background(), cfg, nodes, 1, 10); err == nil || !strings.Contains(err.Error(), "has not registered again") {
+		t.Errorf("client not registered again: %v", err)
+	}
+
+	// back on the new version
+	fake.members[0].Tags["build"] = "1.7.0+ent"
+	fake.nodes[0] = api.Node{Name: "nmdc0", Status: "ready", Version: "1.7.0", ModifyIndex: 12}
+	if err := WaitNode(context.Background(), cfg, nodes, 3, 0); err != nil {
+		t.Errorf("server on the new build: %v", err)
+	}
+	if err := WaitNode(context.Background(), cfg, nodes, 1, 10); err != nil {
+		t.Errorf("client registered again: %v", err)
+	}
+	if since := Registration(cfg, nodes, 1); since != 12 {
+		t.Errorf("registration is %d, want 12", since)
+	}
+}
+
+func TestSameVersion(t *testing.T) {
+	cases := []struct {
+		want, got string
+		same      bool
+	}{
+		{"", "1.6.0", true},
+		{"v1.6.0", "1.6.0", true},
+		{"v1.6.0 (abc123)", "1.6.0", true},
+		{"v1.6.0", "1.6.0+ent", true},
+		{"v1.6.0", "1.6.1", false},
+		{"v1.6.0", "1.6.0-dev", false},
+		{"v1.6.0", "", false},
+	}
+	for _, c := range cases {
+		if got := sameVersion(c.want, c.got); got != c.same {
+			t.Errorf("sameVersion(%q, %q) = %v", c.want, c.got, got)
+		}
+	}
+}
+
+func TestUpgradeNodesSameBinary(t *testing.T) {
+	cfg, recorder := testConfig(t)
+	nodes := MakeNodes(cfg)
+	err := UpgradeNodes(context.Background(), cfg, nodes, "nomad", "v1.6.0", "servers")
+	if err == nil || !strings.Contains(err.Error(), "every node already runs nomad") {
+		t.Errorf("error is %v", err)
+	}
+	if len(recorder.Commands) != 0 {
+		t.Errorf("ran %v", recorder.Commands)
+	}
+}
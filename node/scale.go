@@ -0,0 +1,214 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/mmcquillan/nomad-box/config"
+	"github.com/mmcquillan/nomad-box/network"
+	"github.com/mmcquillan/nomad-box/run"
+)
+
+func ScaleNodes(ctx context.Context, cfg config.Config, nodes []Node, clients int) (config.Config, []Node, error) {
+
+	run.Header("Scaling Clients to " + strconv.Itoa(clients))
+	var err error
+	for clientCount(nodes) < clients {
+		cfg, nodes, err = AddNode(ctx, cfg, nodes, "")
+		if err != nil {
+			return cfg, nodes, err
+		}
+	}
+
+	// newest clients go first
+	for clientCount(nodes) > clients {
+		last := -1
+		for i := 0; i < len(nodes); i++ {
+			if !nodes[i].Server && (last < 0 || nodes[i].Index > nodes[last].Index) {
+				last = i
+			}
+		}
+		cfg, nodes, err = RemoveNode(ctx, cfg, nodes, last)
+		if err != nil {
+			return cfg, nodes, err
+		}
+	}
+	return cfg, nodes, nil
+}
+
+// AddNode adds a client and waits for it to register, cancelling ctx stops the wait
+func AddNode(ctx context.Context, cfg config.Config, nodes []Node, group string) (config.Config, []Node, error) {
+
+	// the named client group or the last one
+	g := -1
+	for j := range cfg.Groups {
+		if !cfg.Groups[j].Server && (group == "" || cfg.Groups[j].Name == group) {
+			g = j
+		}
+	}
+	if g < 0 {
+		return cfg, nodes, errors.New("no client group " + group + " to add a node to")
+	}
+
+	// next free address and client number, the first is the bind server's like at up
+	var err error
+	cfg.Ips, err = network.CidrToIps(cfg.Cidr)
+	if err != nil {
+		return cfg, nodes, err
+	}
+	if cfg.BindServer != "" && len(cfg.Ips) > 0 {
+		if ip := network.GetIpFromDevice(cfg.BindServer); ip != "" {
+			cfg.Ips[0] = ip
+		}
+	}
+	marker := freeIp(cfg, nodes)
+	if marker < 0 {
+		return cfg, nodes, errors.New("no free ips left in " + cfg.Cidr)
+	}
+	c := 0
+	for i := 0; i < len(nodes); i++ {
+		if !nodes[i].Server && nodes[i].Index >= c {
+			c = nodes[i].Index + 1
+		}
+	}
+	node := clientNode(cfg, g, c, marker)
+	for i := 0; i < len(nodes); i++ {
+		if nodes[i].Binary == node.Binary {
+			node.Version = nodes[i].Version
+		}
+	}
+
+	// only the running cluster can supervise, anywhere else the agent is left detached
+	start := cfg
+	if !supervising(cfg) {
+		if node.Restart.Policy != "" && node.Restart.Policy != "never" {
+			return cfg, nodes, errors.New("restart policy " + node.Restart.Policy + " of " + node.Name + " needs an attached cluster, add it from the console or control api of up")
+		}
+		start.Detach = true
+	}
+
+	// build and start it like any other node
+	run.Header("Adding Node")
+	printNode(node)
+	if err := makeNodeResources(cfg, node); err != nil {
+		cleanNodeResources(cfg, node)
+		return cfg, nodes, err
+	}
+	if cfg.TLS {
+		ca, err := makeCA(cfg)
+		if err == nil {
+			err = makeNodeTLS(cfg, ca, node)
+		}
+		if err != nil {
+			cleanNodeResources(cfg, node)
+			return cfg, nodes, &PhaseError{Phase: PhaseLaunch, Node: node.Name, Err: err}
+		}
+	}
+	lock.Lock()
+	nodes = append(nodes, node)
+	lock.Unlock()
+	if err := startNode(start, nodes, len(nodes)-1); err != nil {
+		cleanNodeResources(cfg, node)
+		lock.Lock()
+		nodes = nodes[:len(nodes)-1]
+		lock.Unlock()
+		return cfg, nodes, &PhaseError{Phase: PhaseLaunch, Node: node.Name, Err: err}
+	}
+	cfg.Groups[g].Count++
+	cfg.Clients++
+
+	return cfg, nodes, WaitNode(ctx, cfg, nodes, len(nodes)-1, 0)
+}
+
+// RemoveNode drains, stops and cleans a client, cancelling ctx stops the drain wait
+func RemoveNode(ctx context.Context, cfg config.Config, nodes []Node, i int) (config.Config, []Node, error) {
+
+	if nodes[i].Server {
+		return cfg, nodes, errors.New("only clients can be removed, " + nodes[i].Name + " is a server")
+	}
+
+	// move the work off first, forced when not waiting, a dead agent has nothing to drain
+	run.Header("Removing Node")
+	printNode(nodes[i])
+	deadline := cfg.ReadyTimeout
+	if deadline <= 0 {
+		deadline = -1
+	}
+	if err := DrainNode(ctx, cfg, nodes, i, deadline, deadline > 0); err != nil {
+		run.Warn("Cannot Drain " + nodes[i].Name + ": " + err.Error())
+	}
+	client, id, lookup := nomadNode(cfg, nodes, i)
+	node := stopNode(nodes, i)
+	cleanNodeProcess(cfg, node)
+	if lookup == nil {
+		if err := client.PurgeNode(id); err != nil {
+			run.Warn("Cannot Purge " + node.Name + ": " + err.Error())
+		}
+	}
+	if err := cleanNodeResources(cfg, node); err != nil {
+		run.Warn("Cannot Clean " + node.Name + ": " + err.Error())
+	}
+	if cfg.TLS {
+		run.Command(cfg.Runner, "rm -f "+TLSCert(cfg, node.Name)+" "+TLSKey(cfg, node.Name)+" "+tlsConfigFile(cfg, node))
+	}
+
+	// keep the spec in step with the nodes, a state from before groups were kept goes by placement
+	if j := node.Group; j >= 0 && j < len(cfg.Groups) && groupOf(cfg.Groups[j], node) {
+		cfg.Groups[j].Count--
+	} else {
+		for j := range cfg.Groups {
+			if groupOf(cfg.Groups[j], node) {
+				cfg.Groups[j].Count--
+				break
+			}
+		}
+	}
+	cfg.Clients--
+
+	lock.Lock()
+	nodes = append(nodes[:i], nodes[i+1:]...)
+	lock.Unlock()
+	return cfg, nodes, nil
+}
+
+// supervising is true in the process that runs the cluster attached
+func supervising(cfg config.Config) bool {
+	lock.Lock()
+	defer lock.Unlock()
+	return !cfg.Detach && attached != nil && attached.Config.Directory == cfg.Directory
+}
+
+func groupOf(g config.Group, node Node) bool {
+	return !g.Server && g.Count > 0 && g.Region == node.Region && g.Dc == node.Dc && g.Pool == node.Pool
+}
+
+func freeIp(cfg config.Config, nodes []Node) int {
+
+	// the last address is the bridge in netns mode
+	last := len(cfg.Ips)
+	if cfg.Netns {
+		last--
+	}
+	for marker := 0; marker < last; marker++ {
+		used := false
+		for i := 0; i < len(nodes); i++ {
+			if nodes[i].Ip == cfg.Ips[marker] {
+				used = true
+			}
+		}
+		if !used {
+			return marker
+		}
+	}
+	return -1
+}
+
+func clientCount(nodes []Node) (count int) {
+	for i := 0; i < len(nodes); i++ {
+		if !nodes[i].Server {
+			count++
+		}
+	}
+	return count
+}
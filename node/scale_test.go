@@ -0,0 +1,121 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAddNodeBindServer(t *testing.T) {
+	cfg, _ := testConfig(t)
+	cfg.BindServer = "lo"
+	cfg.Ips[0] = "127.0.0.1"
+	nodes := MakeNodes(cfg)
+
+	// the first address of the cidr is the bind server's even though no node has it
+	cfg, nodes, err := AddNode(context.Background(), cfg, nodes, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	added := nodes[len(nodes)-1]
+	if added.Ip != "10.10.10.3" || added.Device != "nmdeth2" {
+		t.Errorf("added %s on %s, want 10.10.10.3 on nmdeth2", added.Ip, added.Device)
+	}
+	if cfg.Groups[1].Count != 2 || cfg.Clients != 2 {
+		t.Errorf("client group has %d nodes and the cluster %d clients", cfg.Groups[1].Count, cfg.Clients)
+	}
+}
+
+func TestRemoveNodeGroup(t *testing.T) {
+	cfg, _ := testConfig(t)
+	cfg.Ips = []string{"127.0.0.1", "127.0.0.2", "127.0.0.3"}
+	cfg.ReadyTimeout = 0
+
+	// two client groups in the same place, told apart only by their class
+	gpu := cfg.Groups[1]
+	gpu.Name, gpu.NodeClass = "gpu", "gpu"
+	cfg.Groups = append(cfg.Groups, gpu)
+	cfg.Clients = 2
+	nodes := MakeNodes(cfg)
+	if nodes[2].Group != 2 {
+		t.Fatalf("%s is in group %d", nodes[2].Name, nodes[2].Group)
+	}
+
+	cfg, nodes, err := RemoveNode(context.Background(), cfg, nodes, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Groups[1].Count != 1 || cfg.Groups[2].Count != 0 || len(nodes) != 2 {
+		t.Errorf("groups are %d and %d with %d nodes left", cfg.Groups[1].Count, cfg.Groups[2].Count, len(nodes))
+	}
+}
+
+func TestAddNodeRestartPolicy(t *testing.T) {
+	cfg, recorder := testConfig(t)
+	cfg.Detach = false
+	cfg.Groups[1].Restart.Policy = "on-failure"
+	nodes := MakeNodes(cfg)
+
+	// nothing runs the cluster here, so nothing could restart the agent
+	if _, _, err := AddNode(context.Background(), cfg, nodes, ""); err == nil {
+		t.Fatal("added a client with a restart policy to an unattached cluster")
+	}
+	if len(recorder.Commands) != 0 {
+		t.Errorf("ran %v", recorder.Commands)
+	}
+
+	// the running cluster supervises it
+	Attach(&State{Config: cfg, Nodes: nodes})
+	defer Attach(nil)
+	cfg, nodes, err := AddNode(context.Background(), cfg, nodes, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := recorder.Commands[len(recorder.Commands)-1]; !strings.Contains(last, "-node=nmdc1 ") {
+		t.Fatalf("last command is %s", last)
+	}
+	lock.Lock()
+	_, watched := stopping["nmdc1"]
+	lock.Unlock()
+	if !watched {
+		t.Error("nmdc1 is not supervised")
+	}
+	stopNode(nodes, len(nodes)-1)
+}
+
+func TestCheckOwner(t *testing.T) {
+	cfg, recorder := testConfig(t)
+	nodes := MakeNodes(cfg)
+
+	// the attached process records itself as the owner
+	Attach(&State{Config: cfg, Nodes: nodes})
+	defer Attach(nil)
+	if err := SaveState(cfg, nodes); err != nil {
+		t.Fatal(err)
+	}
+	var saved State
+	if err := json.Unmarshal([]byte(recorder.Steps[len(recorder.Steps)-1].Content), &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Owner != os.Getpid() {
+		t.Errorf("owner is %d, want %d", saved.Owner, os.Getpid())
+	}
+
+	cases := []struct {
+		name  string
+		owner int
+		fails bool
+	}{
+		{name: "none"},
+		{name: "this process", owner: os.Getpid()},
+		{name: "another live process", owner: os.Getppid(), fails: true},
+	}
+	for _, c := range cases {
+		err := CheckOwner(State{Config: cfg, Nodes: nodes, Owner: c.owner})
+		if (err != nil) != c.fails {
+			t.Errorf("%s: error is %v", c.name, err)
+		}
+	}
+}
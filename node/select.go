@@ -0,0 +1,68 @@
+package node
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/mmcquillan/nomad-box/config"
+)
+
+func FindNode(cfg config.Config, nodes []Node, name string) (int, error) {
+	for i := 0; i < len(nodes); i++ {
+		if nodes[i].Name == name || nodes[i].Name == cfg.Prefix+name {
+			return i, nil
+		}
+	}
+	return -1, errors.New("no node named " + name)
+}
+
+func FindNodes(cfg config.Config, nodes []Node, names string) (found []Node, err error) {
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		// an exact name wins, then ranges like c0-c3 or c0-3
+		if i, err := FindNode(cfg, nodes, name); err == nil {
+			found = append(found, nodes[i])
+			continue
+		}
+		prefix, from, to, ok := nodeRange(name)
+		if !ok {
+			return found, errors.New("no node named " + name)
+		}
+		if to < from {
+			return found, errors.New("invalid node range " + name)
+		}
+		for n := from; n <= to; n++ {
+			i, err := FindNode(cfg, nodes, prefix+strconv.Itoa(n))
+			if err != nil {
+				return found, err
+			}
+			found = append(found, nodes[i])
+		}
+	}
+	return found, nil
+}
+
+func nodeRange(name string) (prefix string, from int, to int, ok bool) {
+	start, end, found := strings.Cut(name, "-")
+	if !found {
+		return "", 0, 0, false
+	}
+	prefix = strings.TrimRight(start, "0123456789")
+	if prefix == start {
+		return "", 0, 0, false
+	}
+	from, err := strconv.Atoi(strings.TrimPrefix(start, prefix))
+	if err != nil {
+		return "", 0, 0, false
+	}
+	to, err = strconv.Atoi(strings.TrimPrefix(end, prefix))
+	if err != nil {
+		return "", 0, 0, false
+	}
+	return prefix, from, to, true
+}
@@ -0,0 +1,48 @@
+package node
+
+import (
+	"testing"
+
+	"github.com/mmcquillan/nomad-box/config"
+)
+
+func TestFindNodes(t *testing.T) {
+	cfg := config.Config{Prefix: "nmd"}
+	nodes := []Node{{Name: "nmds0"}, {Name: "nmdc0"}, {Name: "nmdc1"}, {Name: "nmdc2"}, {Name: "web-api"}}
+
+	cases := []struct {
+		names string
+		want  []string
+		err   bool
+	}{
+		{names: "c0-c2", want: []string{"nmdc0", "nmdc1", "nmdc2"}},
+		{names: "c1-2", want: []string{"nmdc1", "nmdc2"}},
+		{names: "s0,c2", want: []string{"nmds0", "nmdc2"}},
+		{names: "web-api", want: []string{"web-api"}},
+		{names: "web-1", err: true},
+		{names: "c2-c1", err: true},
+		{names: "c0-c5", err: true},
+	}
+	for _, c := range cases {
+		found, err := FindNodes(cfg, nodes, c.names)
+		if c.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", c.names)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.names, err)
+			continue
+		}
+		if len(found) != len(c.want) {
+			t.Errorf("%s: found %d nodes, want %d", c.names, len(found), len(c.want))
+			continue
+		}
+		for i := range found {
+			if found[i].Name != c.want[i] {
+				t.Errorf("%s: found %s, want %s", c.names, found[i].Name, c.want[i])
+			}
+		}
+	}
+}
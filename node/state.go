@@ -0,0 +1,49 @@
+package node
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+
+	"github.com/mmcquillan/nomad-box/config"
+	"github.com/mmcquillan/nomad-box/run"
+)
+
+type State struct {
+	Config config.Config
+	Nodes  []Node
+	Owner  int `json:",omitempty"`
+}
+
+func StateFile(cfg config.Config) string {
+	return cfg.Directory + "/state.json"
+}
+
+func SaveState(cfg config.Config, nodes []Node) error {
+	state := State{Config: cfg, Nodes: nodes, Owner: owner(cfg)}
+	state_json, err := json.MarshalIndent(state, "", "   ")
+	if err != nil {
+		return err
+	}
+	if err := run.Command(cfg.Runner, "mkdir -p "+cfg.Directory); err != nil {
+		return err
+	}
+	// the state holds the gossip key, so only the owner reads it
+	return runner(cfg).WriteFile(StateFile(cfg), state_json, 0600)
+}
+
+func LoadState(cfg config.Config) (state State, err error) {
+	file, err := os.ReadFile(StateFile(cfg))
+	if errors.Is(err, os.ErrNotExist) {
+		return state, errors.New("no cluster state found in " + cfg.Directory)
+	}
+	if err != nil {
+		return state, err
+	}
+	err = json.Unmarshal(file, &state)
+	return state, err
+}
+
+func RemoveState(cfg config.Config) error {
+	return run.Command(cfg.Runner, "rm -f "+StateFile(cfg))
+}
@@ -0,0 +1,68 @@
+package node
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mmcquillan/nomad-box/config"
+	"github.com/mmcquillan/nomad-box/run"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	cfg, _ := testConfig(t)
+	cfg.Runner = run.Shell{}
+	cfg.Directory = t.TempDir() + "/cluster"
+	cfg.GossipKey = "Zm9vYmFyYmF6cXV4Zm9vYmFyYmF6cXV4Zm9vYmFyYmE="
+	cfg.Groups[1].Meta = map[string]string{"rack": "r1"}
+	cfg.Groups[1].HostVolumes = []config.HostVolume{{Name: "data", ReadOnly: true}}
+	cfg.Groups[1].Restart = config.Restart{Policy: "on-failure", Backoff: 2 * time.Second}
+	nodes := MakeNodes(cfg)
+	nodes[0].Pid = 4242
+	nodes[0].Started = time.Now().UTC().Round(0)
+	nodes[1].Restarts = 3
+	nodes[1].ExitCode = 1
+
+	if _, err := LoadState(cfg); err == nil || !strings.Contains(err.Error(), "no cluster state found") {
+		t.Errorf("load before save is %v", err)
+	}
+
+	// the directory is made and only the owner reads the file
+	if err := SaveState(cfg, nodes); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(StateFile(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("state is written %s", info.Mode())
+	}
+
+	// everything but the runner and links comes back
+	state, err := LoadState(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(state.Nodes, nodes) {
+		t.Errorf("nodes are\n%+v\nwant\n%+v", state.Nodes, nodes)
+	}
+	if state.Config.Directory != cfg.Directory || state.Config.GossipKey != cfg.GossipKey || state.Config.Clients != 1 {
+		t.Errorf("config is %+v", state.Config)
+	}
+	if state.Config.Runner != nil || state.Config.Links != nil || state.Owner != 0 {
+		t.Errorf("runner %v, links %v and owner %d are saved", state.Config.Runner, state.Config.Links, state.Owner)
+	}
+
+	// an attached cluster records this process as its owner
+	Attach(&State{Config: cfg, Nodes: nodes})
+	defer Attach(nil)
+	if err := SaveState(cfg, nodes); err != nil {
+		t.Fatal(err)
+	}
+	if state, err = LoadState(cfg); err != nil || state.Owner != os.Getpid() {
+		t.Errorf("owner is %d (%v), want %d", state.Owner, err, os.Getpid())
+	}
+}
@@ -0,0 +1,177 @@
+package node
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mmcquillan/nomad-box/config"
+	"github.com/mmcquillan/nomad-box/run"
+)
+
+var (
+	lock     sync.Mutex
+	stopping = make(map[string]bool)
+	attached *State
+	busy     sync.Mutex
+	// directory of the attached cluster, SaveState reads it while lock is held
+	owned atomic.Value
+)
+
+// Attach lets supervisors follow a running cluster whose nodes are added and removed
+func Attach(state *State) {
+	lock.Lock()
+	defer lock.Unlock()
+	attached = state
+	if state != nil {
+		owned.Store(state.Config.Directory)
+	} else {
+		owned.Store("")
+	}
+}
+
+// owner is this process when it supervises the cluster in the directory of cfg
+func owner(cfg config.Config) int {
+	if dir, _ := owned.Load().(string); dir != "" && dir == cfg.Directory {
+		return os.Getpid()
+	}
+	return 0
+}
+
+func startNode(cfg config.Config, nodes []Node, i int) error {
+
+	command := nodeCommand(nodes[i], agentCommand(cfg, nodes, i))
+
+	// detached agents are left to themselves
+	if cfg.Detach {
+		pid, err := runner(cfg).Daemon(command, LogFile(nodes[i]))
+		if err != nil {
+			return err
+		}
+		nodes[i].Pid = pid
+		nodes[i].Started = time.Now()
+		return nil
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	stopping[nodes[i].Name] = false
+	pid, exit, err := runner(cfg).Process(command, nodes[i].Name, cfg.Log, LogFile(nodes[i]))
+	if err != nil {
+		return err
+	}
+	nodes[i].Pid = pid
+	nodes[i].Started = time.Now()
+	go superviseNode(cfg, nodes, i, command, exit)
+	return nil
+
+}
+
+func stopNode(nodes []Node, i int) Node {
+	lock.Lock()
+	defer lock.Unlock()
+	stopping[nodes[i].Name] = true
+	return nodes[i]
+}
+
+func superviseNode(cfg config.Config, nodes []Node, i int, command string, exit chan int) {
+
+	lock.Lock()
+	name := nodes[i].Name
+	pid := nodes[i].Pid
+	lock.Unlock()
+
+	for {
+
+		// wait for the agent to exit, someone else may have taken over the node
+		code := <-exit
+		lock.Lock()
+		cfg, nodes = supervised(cfg, nodes)
+		i = nodeIndex(nodes, name)
+		if i < 0 || nodes[i].Pid != pid {
+			lock.Unlock()
+			return
+		}
+		nodes[i].ExitCode = code
+		node := nodes[i]
+		stopped := stopping[node.Name]
+		lock.Unlock()
+		if stopped {
+			return
+		}
+		if !shouldRestart(node.Restart, node.Restarts, code) {
+			run.Warn(node.Name + " exited with " + strconv.Itoa(code))
+			return
+		}
+
+		// back off before restarting
+		backoff := node.Restart.Backoff
+		if backoff <= 0 {
+			backoff = time.Second
+		}
+		backoff = backoff << node.Restarts
+		if backoff > time.Minute || backoff <= 0 {
+			backoff = time.Minute
+		}
+		run.Warn(node.Name + " exited with " + strconv.Itoa(code) + ", restarting in " + backoff.String())
+		time.Sleep(backoff)
+
+		// restart unless we are shutting down
+		lock.Lock()
+		cfg, nodes = supervised(cfg, nodes)
+		i = nodeIndex(nodes, name)
+		if stopping[node.Name] || i < 0 || nodes[i].Pid != pid {
+			lock.Unlock()
+			return
+		}
+		var err error
+		pid, exit, err = runner(cfg).Process(command, node.Name, cfg.Log, LogFile(node))
+		if err != nil {
+			lock.Unlock()
+			run.Error("Cannot Restart " + node.Name)
+			run.Error(err.Error())
+			return
+		}
+		nodes[i].Pid = pid
+		nodes[i].Started = time.Now()
+		nodes[i].Restarts++
+		if err := SaveState(cfg, nodes); err != nil {
+			run.Error("Cannot Save State")
+			run.Error(err.Error())
+		}
+		lock.Unlock()
+
+	}
+}
+
+// the attached cluster when there is one, its nodes move as it scales
+func supervised(cfg config.Config, nodes []Node) (config.Config, []Node) {
+	if attached != nil {
+		return attached.Config, attached.Nodes
+	}
+	return cfg, nodes
+}
+
+func nodeIndex(nodes []Node, name string) int {
+	for i := 0; i < len(nodes); i++ {
+		if nodes[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func shouldRestart(restart config.Restart, restarts int, code int) bool {
+	if restart.Max > 0 && restarts >= restart.Max {
+		return false
+	}
+	switch restart.Policy {
+	case "always":
+		return true
+	case "on-failure":
+		return code != 0
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package node
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/mmcquillan/nomad-box/config"
+	"github.com/mmcquillan/nomad-box/run"
+)
+
+// fields a node config template can use
+type templateData struct {
+	Name   string
+	Ip     string
+	Dc     string
+	Region string
+	Pool   string
+	Index  int
+}
+
+// renderNodeConfig renders the node config, each file of a directory into a directory of the node
+func renderNodeConfig(cfg config.Config, node Node) error {
+	files, err := config.TemplateFiles(node.Config)
+	if err != nil {
+		return err
+	}
+	if !nodeConfigIsDir(node) {
+		return renderTemplate(cfg, node, files[0], nodeConfigFile(node))
+	}
+	if err := run.Command(cfg.Runner, "mkdir -p "+nodeConfigDir(node)); err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := renderTemplate(cfg, node, file, nodeConfigDir(node)+"/"+filepath.Base(file)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func renderTemplate(cfg config.Config, node Node, file string, path string) error {
+	tmpl, err := template.New(filepath.Base(file)).Option("missingkey=error").ParseFiles(file)
+	if err != nil {
+		return err
+	}
+	var rendered bytes.Buffer
+	err = tmpl.Execute(&rendered, templateData{
+		Name:   node.Name,
+		Ip:     node.Ip,
+		Dc:     node.Dc,
+		Region: node.Region,
+		Pool:   node.Pool,
+		Index:  node.Index,
+	})
+	if err != nil {
+		return err
+	}
+	return runner(cfg).WriteFile(path, rendered.Bytes(), 0644)
+}
+
+// nodeConfigPath is what the agent loads, the rendered file or directory
+func nodeConfigPath(node Node) string {
+	if nodeConfigIsDir(node) {
+		return nodeConfigDir(node)
+	}
+	return nodeConfigFile(node)
+}
+
+func nodeConfigIsDir(node Node) bool {
+	info, err := os.Stat(node.Config)
+	return err == nil && info.IsDir()
+}
+
+// nodeConfigFile keeps a json source json, nomad reads any other extension as hcl
+func nodeConfigFile(node Node) string {
+	if filepath.Ext(node.Config) == ".json" {
+		return node.Dir + "/agent.json"
+	}
+	return node.Dir + "/agent.hcl"
+}
+
+func nodeConfigDir(node Node) string {
+	return node.Dir + "/config.d"
+}
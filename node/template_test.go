@@ -0,0 +1,65 @@
+package node
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRenderNodeConfig(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "agent.hcl"), []byte("name = \"{{ .Name }}\"\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "ports.json"), []byte("{\"bind_addr\": \"{{ .Ip }}\"}\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "README"), []byte("{{ .Missing }}\n"), 0644)
+
+	cases := []struct {
+		name   string
+		config string
+		arg    string
+		files  map[string]string
+	}{
+		{
+			name:   "file",
+			config: filepath.Join(dir, "agent.hcl"),
+			arg:    "-config=/tmp/nmd-test/nmdc0/agent.hcl",
+			files:  map[string]string{"/tmp/nmd-test/nmdc0/agent.hcl": "name = \"nmdc0\"\n"},
+		},
+		{
+			name:   "json file",
+			config: filepath.Join(dir, "ports.json"),
+			arg:    "-config=/tmp/nmd-test/nmdc0/agent.json",
+			files:  map[string]string{"/tmp/nmd-test/nmdc0/agent.json": "{\"bind_addr\": \"10.10.10.2\"}\n"},
+		},
+		{
+			name:   "directory",
+			config: dir,
+			arg:    "-config=/tmp/nmd-test/nmdc0/config.d",
+			files: map[string]string{
+				"/tmp/nmd-test/nmdc0/config.d/agent.hcl":  "name = \"nmdc0\"\n",
+				"/tmp/nmd-test/nmdc0/config.d/ports.json": "{\"bind_addr\": \"10.10.10.2\"}\n",
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg, recorder := testConfig(t)
+			cfg.ClientConfig = c.config
+			nodes := MakeNodes(cfg)
+
+			if err := renderNodeConfig(cfg, nodes[1]); err != nil {
+				t.Fatal(err)
+			}
+			if len(recorder.Files) != len(c.files) {
+				t.Errorf("wrote %d files, want %d", len(recorder.Files), len(c.files))
+			}
+			for path, content := range c.files {
+				if string(recorder.Files[path]) != content {
+					t.Errorf("%s is %q, want %q", path, recorder.Files[path], content)
+				}
+			}
+			if !contains(agentArgs(cfg, nodes, 1), c.arg) {
+				t.Errorf("agent args have no %s", c.arg)
+			}
+		})
+	}
+}
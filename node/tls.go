@@ -0,0 +1,73 @@
+package node
+
+import (
+	"github.com/mmcquillan/nomad-box/certs"
+	"github.com/mmcquillan/nomad-box/config"
+	"github.com/mmcquillan/nomad-box/run"
+)
+
+func TLSDir(cfg config.Config) string {
+	return cfg.Directory + "/tls"
+}
+
+func TLSCert(cfg config.Config, name string) string {
+	return TLSDir(cfg) + "/" + name + ".pem"
+}
+
+func TLSKey(cfg config.Config, name string) string {
+	return TLSDir(cfg) + "/" + name + "-key.pem"
+}
+
+func makeCA(cfg config.Config) (certs.CA, error) {
+
+	// ca and a certificate for the cli
+	if err := run.Command(cfg.Runner, "mkdir -p "+TLSDir(cfg)); err != nil {
+		return certs.CA{}, err
+	}
+	ca, err := certs.LoadOrMakeCA(cfg.Runner, TLSCert(cfg, "ca"), TLSKey(cfg, "ca"))
+	if err != nil {
+		return ca, err
+	}
+	err = certs.MakeCert(cfg.Runner, ca, TLSCert(cfg, "cli"), TLSKey(cfg, "cli"), "cli", []string{"cli.global.nomad", "localhost"}, nil)
+	return ca, err
+
+}
+
+func makeNodeTLS(cfg config.Config, ca certs.CA, node Node) error {
+
+	// nomad checks the role and region in the certificate name
+	role := "client"
+	if node.Server {
+		role = "server"
+	}
+	dnsNames := []string{role + "." + node.Region + ".nomad", "localhost"}
+	ips := []string{node.Ip, "127.0.0.1"}
+	err := certs.MakeCert(cfg.Runner, ca, TLSCert(cfg, node.Name), TLSKey(cfg, node.Name), node.Name, dnsNames, ips)
+	if err != nil {
+		return err
+	}
+
+	// write tls config
+	config := []byte(`tls {
+  http = true
+  rpc  = true
+
+  ca_file   = "` + TLSCert(cfg, "ca") + `"
+  cert_file = "` + TLSCert(cfg, node.Name) + `"
+  key_file  = "` + TLSKey(cfg, node.Name) + `"
+
+  verify_server_hostname = true
+  verify_https_client    = true
+}
+`)
+	return runner(cfg).WriteFile(tlsConfigFile(cfg, node), config, 0644)
+
+}
+
+func cleanTLS(cfg config.Config) {
+	run.Command(cfg.Runner, "rm -rf "+TLSDir(cfg))
+}
+
+func tlsConfigFile(cfg config.Config, node Node) string {
+	return TLSDir(cfg) + "/" + node.Name + ".hcl"
+}
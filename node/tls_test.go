@@ -0,0 +1,42 @@
+package node
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/mmcquillan/nomad-box/certs"
+)
+
+func TestMakeNodeTLS(t *testing.T) {
+	cfg, recorder := testConfig(t)
+	cfg.TLS = true
+	cfg.Directory = t.TempDir()
+	nodes := MakeNodes(cfg)
+	ca, err := certs.LoadOrMakeCA(recorder, TLSCert(cfg, "ca"), TLSKey(cfg, "ca"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Cert)
+
+	// nomad checks the role and region, the http api is reached on the node ip and localhost
+	for i, name := range []string{"server.global.nomad", "client.global.nomad"} {
+		if err := makeNodeTLS(cfg, ca, nodes[i]); err != nil {
+			t.Fatal(err)
+		}
+		block, _ := pem.Decode(recorder.Files[TLSCert(cfg, nodes[i].Name)])
+		if block == nil {
+			t.Fatalf("no cert for %s", nodes[i].Name)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, host := range []string{name, "localhost", nodes[i].Ip, "127.0.0.1"} {
+			if _, err := cert.Verify(x509.VerifyOptions{Roots: roots, DNSName: host}); err != nil {
+				t.Errorf("%s: %v", nodes[i].Name, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package node
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mmcquillan/nomad-box/config"
+	"github.com/mmcquillan/nomad-box/run"
+)
+
+// UpgradeNodes restarts every node not on binary with it, one at a time, cancelling ctx stops between waits
+func UpgradeNodes(ctx context.Context, cfg config.Config, nodes []Node, binary string, version string, order string) error {
+
+	// nothing to do is a mistake, such as upgrading to the binary already running
+	upgrade := 0
+	for i := 0; i < len(nodes); i++ {
+		if nodes[i].Binary != binary {
+			upgrade++
+		}
+	}
+	if upgrade == 0 {
+		return errors.New("every node already runs " + binary)
+	}
+
+	run.Header("Upgrading Nodes")
+
+	// servers first is the usual order, clients first on request
+	serversFirst := order != "clients"
+	for _, servers := range []bool{serversFirst, !serversFirst} {
+		for i := 0; i < len(nodes); i++ {
+			if nodes[i].Server != servers || nodes[i].Binary == binary {
+				continue
+			}
+
+			// swap the binary and bring the agent back on the same data dir
+			since := Registration(cfg, nodes, i)
+			nodes[i].Binary = binary
+			nodes[i].Version = version
+			printNode(nodes[i])
+			if err := RestartNode(cfg, nodes, i); err != nil {
+				return errors.New(nodes[i].Name + " did not start: " + err.Error())
+			}
+			if err := SaveState(cfg, nodes); err != nil {
+				run.Error("Cannot Save State")
+				run.Error(err.Error())
+			}
+
+			// rejoined on the new version before moving on
+			if err := WaitNode(ctx, cfg, nodes, i, since); err != nil {
+				return errors.New(nodes[i].Name + " did not rejoin: " + err.Error())
+			}
+
+		}
+	}
+
+	run.Out("Upgrade Complete")
+	return nil
+}
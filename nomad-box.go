@@ -1,9 +1,15 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
+	"os/exec"
 	"os/signal"
+	"strings"
 
 	"github.com/mmcquillan/nomad-box/checks"
 	"github.com/mmcquillan/nomad-box/config"
@@ -11,43 +17,654 @@ import (
 	"github.com/mmcquillan/nomad-box/run"
 )
 
+// exit codes so scripts can tell where up failed
+const (
+	exitFailure = 1
+	exitCheck   = 2
+	exitNetwork = 3
+	exitLaunch  = 4
+	exitReady   = 5
+)
+
 func main() {
 
 	// configurable variables
-	cfg := config.MakeConfig()
-	checks.Checks(&cfg)
+	cfg, err := config.MakeConfig()
+	if err != nil {
+		run.Error(err.Error())
+		os.Exit(exitCheck)
+	}
+
+	// run the command
+	switch cfg.Command {
+	case "up":
+		up(cfg)
+	case "status":
+		status(cfg)
+	case "down":
+		down(cfg)
+	case "partition":
+		partition(cfg)
+	case "heal":
+		heal(cfg)
+	case "netem":
+		netem(cfg)
+	case "chaos":
+		chaos(cfg)
+	case "upgrade":
+		upgrade(cfg)
+	case "keyring":
+		keyring(cfg)
+	case "scale":
+		scale(cfg)
+	case "add-node":
+		addNode(cfg)
+	case "remove-node":
+		removeNode(cfg)
+	case "drain":
+		drain(cfg)
+	case "eligibility":
+		eligibility(cfg)
+	default:
+		run.Error("Unknown command " + cfg.Command)
+		os.Exit(exitFailure)
+	}
+
+}
+
+func up(cfg config.Config) {
+
+	// plan json has stdout to itself
+	if cfg.Format != "text" && cfg.Format != "json" {
+		run.Error("Unknown output format " + cfg.Format + " (text, json)")
+		os.Exit(exitCheck)
+	}
+	if cfg.Plan && cfg.Format == "json" {
+		run.Output = os.Stderr
+	}
+
+	// pre checks
+	if err := checks.Checks(&cfg); err != nil {
+		os.Exit(exitCheck)
+	}
 
 	// make nodes
 	nodes := node.MakeNodes(cfg)
 
 	// clean
 	if cfg.Clean {
+		node.HealNodes(cfg, nodes)
 		node.CleanNodeResources(cfg, nodes)
+		node.RemoveState(cfg)
 		os.Exit(0)
 	}
 
-	// plan exit
+	// plan shows every command, file and agent up would run
 	if cfg.Plan {
+		plan, err := node.PlanNodes(cfg, nodes)
+		if cfg.Format == "json" {
+			plan_json, err := json.MarshalIndent(plan, "", "   ")
+			if err != nil {
+				run.Error("Cannot Encode Plan")
+				run.Error(err.Error())
+				os.Exit(exitFailure)
+			}
+			fmt.Println(string(plan_json))
+		} else {
+			node.PrintPlan(plan)
+		}
+		if err != nil {
+			run.Error(err.Error())
+			os.Exit(exitCode(err))
+		}
 		run.Out("Plan Mode (quitting)")
 		os.Exit(0)
 	}
 
-	// start up nodes
-	node.BuildNodes(cfg, nodes)
+	// check for a running cluster
+	if err := node.CheckRunning(cfg); err != nil {
+		run.Error(err.Error())
+		os.Exit(exitFailure)
+	}
+
+	// attached from the start so the recorded state names this process as its owner
+	state := &node.State{Config: cfg, Nodes: nodes}
+	if !cfg.Detach {
+		node.Attach(state)
+	}
+
+	// start up nodes and wait for them, a failed build is already rolled back, ctrl-c stops the waits
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	token, err := node.StartNodes(ctx, cfg, nodes)
+	cancel()
+	if err != nil {
+		run.Error(err.Error())
+		code := exitCode(err)
+		if code == exitReady && !cfg.Detach {
+			cleanNodes(cfg, nodes)
+		}
+		os.Exit(code)
+	}
+	run.Out("export NOMAD_ADDR=\"" + node.Addr(cfg, nodes) + "\"")
+	if cfg.TLS {
+		run.Out("export NOMAD_CACERT=\"" + node.TLSCert(cfg, "ca") + "\"")
+		run.Out("export NOMAD_CLIENT_CERT=\"" + node.TLSCert(cfg, "cli") + "\"")
+		run.Out("export NOMAD_CLIENT_KEY=\"" + node.TLSKey(cfg, "cli") + "\"")
+	}
+	if cfg.ACL {
+		run.Out("export NOMAD_TOKEN=\"" + token + "\"")
+	}
+
+	// leave it running
+	if cfg.Detach {
+		run.Out("Cluster Running (detached, use status or down)")
+		return
+	}
 
-	// setup to catch sigint
+	// quit from the console, the control api or an interrupt
+	quit := make(chan struct{}, 1)
+	stop := func() {
+		select {
+		case quit <- struct{}{}:
+		default:
+		}
+	}
 	q := make(chan os.Signal, 1)
 	signal.Notify(q, os.Interrupt)
 	go func() {
 		<-q
-		node.CleanNodes(cfg, nodes)
+		fmt.Println()
+		stop()
 	}()
 
-	// wait to quit
-	run.Out("Cluster Running (enter to quit)")
-	fmt.Scanln()
+	// serve the control api
+	if cfg.Control != "" {
+		listener, err := node.Control(state, cfg.Control, stop)
+		if err != nil {
+			run.Error("Cannot Start Control API")
+			run.Error(err.Error())
+		} else {
+			defer listener.Close()
+		}
+	}
+
+	// drive the cluster until quit, a console command still waiting is stopped
+	console, cancelConsole := context.WithCancel(context.Background())
+	go func() {
+		node.Console(console, state, os.Stdin)
+		stop()
+	}()
+	<-quit
+	cancelConsole()
 
 	// clean up
-	node.CleanNodes(cfg, nodes)
+	if err := cleanNodes(state.Config, state.Nodes); err != nil {
+		os.Exit(exitFailure)
+	}
+
+}
+
+func status(cfg config.Config) {
+
+	// read the cluster state
+	state, err := node.LoadState(cfg)
+	if err != nil {
+		run.Error(err.Error())
+		os.Exit(exitFailure)
+	}
+
+	node.StatusNodes(state.Config, state.Nodes)
+
+}
+
+func down(cfg config.Config) {
+
+	// read the cluster state
+	state, err := node.LoadState(cfg)
+	if err != nil {
+		run.Error(err.Error())
+		os.Exit(exitFailure)
+	}
+
+	// tear down
+	state.Config.Persist = state.Config.Persist || cfg.Persist
+	if err := cleanNodes(state.Config, state.Nodes); err != nil {
+		os.Exit(exitFailure)
+	}
+
+}
+
+func partition(cfg config.Config) {
+
+	// read the cluster state
+	state, err := node.LoadState(cfg)
+	if err != nil {
+		run.Error(err.Error())
+		os.Exit(exitFailure)
+	}
+
+	// needs iptables
+	if _, err := exec.LookPath("iptables"); err != nil {
+		run.Error("iptables is not installed")
+		os.Exit(exitCheck)
+	}
+
+	// resolve both sides
+	between, err := node.FindNodes(state.Config, state.Nodes, cfg.Between)
+	if err != nil {
+		run.Error(err.Error())
+		os.Exit(exitFailure)
+	}
+	and, err := node.FindNodes(state.Config, state.Nodes, cfg.And)
+	if err != nil {
+		run.Error(err.Error())
+		os.Exit(exitFailure)
+	}
+	if len(between) == 0 || len(and) == 0 {
+		run.Error("partition needs nodes for -between and -and")
+		os.Exit(exitFailure)
+	}
+
+	if err := node.PartitionNodes(state.Config, between, and); err != nil {
+		run.Error("Partition Failed")
+		run.Error(err.Error())
+		os.Exit(exitFailure)
+	}
+
+}
+
+func heal(cfg config.Config) {
+
+	// read the cluster state
+	state, err := node.LoadState(cfg)
+	if err != nil {
+		run.Error(err.Error())
+		os.Exit(exitFailure)
+	}
+
+	node.HealNodes(state.Config, state.Nodes)
+
+}
+
+func netem(cfg config.Config) {
+
+	// read the cluster state
+	state, err := node.LoadState(cfg)
+	if err != nil {
+		run.Error(err.Error())
+		os.Exit(exitFailure)
+	}
+
+	// needs tc
+	if _, err := exec.LookPath("tc"); err != nil {
+		run.Error("tc is not installed")
+		os.Exit(exitCheck)
+	}
+
+	// resolve the nodes
+	if len(cfg.Args) == 0 {
+		run.Error("netem needs nodes to shape (c0,c3)")
+		os.Exit(exitFailure)
+	}
+	if cfg.Netem.Jitter != "" && cfg.Netem.Delay == "" {
+		run.Error("netem jitter needs a delay")
+		os.Exit(exitFailure)
+	}
+	if _, err := exec.LookPath("ping"); err != nil && cfg.Netem.Delay != "" {
+		run.Error("ping is not installed, it checks the delay")
+		os.Exit(exitCheck)
+	}
+	if cfg.Netem != (config.Netem{}) && !state.Config.Netns {
+		run.Error("netem needs a cluster started with -netns, nodes without a namespace talk over lo")
+		os.Exit(exitFailure)
+	}
+	targets, err := node.FindNodes(state.Config, state.Nodes, cfg.Args[0])
+	if err != nil {
+		run.Error(err.Error())
+		os.Exit(exitFailure)
+	}
+
+	// shape and record
+	node.NetemNodes(state.Config, state.Nodes, targets, cfg.Netem)
+	if err := node.SaveState(state.Config, state.Nodes); err != nil {
+		run.Error("Cannot Save State")
+		run.Error(err.Error())
+	}
+
+}
+
+func chaos(cfg config.Config) {
+
+	// read the cluster state
+	state, err := node.LoadState(cfg)
+	if err != nil {
+		run.Error(err.Error())
+		os.Exit(exitFailure)
+	}
+
+	// restarted agents would be unknown to an attached up
+	if err := node.CheckOwner(state); err != nil {
+		run.Error(err.Error())
+		os.Exit(exitFailure)
+	}
+
+	// check the schedule
+	if cfg.Chaos.Interval <= 0 {
+		run.Error("chaos needs a positive -interval")
+		os.Exit(exitFailure)
+	}
+	for _, action := range strings.Split(cfg.Chaos.Actions, ",") {
+		switch strings.TrimSpace(action) {
+		case "kill", "pause", "restart":
+		default:
+			run.Error("Unknown chaos action " + action)
+			os.Exit(exitFailure)
+		}
+	}
+	switch cfg.Chaos.Targets {
+	case "all", "servers", "clients":
+	default:
+		run.Error("Unknown chaos targets " + cfg.Chaos.Targets)
+		os.Exit(exitFailure)
+	}
+
+	node.ChaosNodes(state.Config, state.Nodes, cfg.Chaos)
+
+}
+
+func upgrade(cfg config.Config) {
+
+	// read the cluster state
+	state, err := node.LoadState(cfg)
+	if err != nil {
+		run.Error(err.Error())
+		os.Exit(exitFailure)
+	}
+
+	// an attached up would restart the old agents it supervises
+	if err := node.CheckOwner(state); err != nil {
+		run.Error(err.Error())
+		os.Exit(exitFailure)
+	}
+
+	// the new binary is never the default, it has to be asked for
+	explicit := os.Getenv("NOMAD_BOX_BINARY") != ""
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "binary" {
+			explicit = true
+		}
+	})
+	if !explicit {
+		run.Error("upgrade needs -binary with the new Nomad binary")
+		os.Exit(exitFailure)
+	}
+
+	// check the new binary and order
+	if _, err := os.Stat(cfg.Binary); err != nil {
+		run.Error("Nomad Binary does not exist")
+		os.Exit(exitCheck)
+	}
+	if cfg.Order != "servers" && cfg.Order != "clients" {
+		run.Error("upgrade -order must be servers or clients")
+		os.Exit(exitFailure)
+	}
+
+	// roll the nodes, waiting as long as the flags ask
+	state.Config.ReadyTimeout = cfg.ReadyTimeout
+	version := checks.BinaryVersion(cfg.Runner, cfg.Binary)
+	run.Out("Upgrading to " + cfg.Binary + " " + version)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := node.UpgradeNodes(ctx, state.Config, state.Nodes, cfg.Binary, version, cfg.Order); err != nil {
+		run.Error("Upgrade Failed")
+		run.Error(err.Error())
+		os.Exit(exitFailure)
+	}
+
+}
+
+func keyring(cfg config.Config) {
+
+	// read the cluster state
+	state, err := node.LoadState(cfg)
+	if err != nil {
+		run.Error(err.Error())
+		os.Exit(exitFailure)
+	}
+
+	// list by default
+	action := "list"
+	if len(cfg.Args) > 0 {
+		action = cfg.Args[0]
+	}
+
+	switch action {
+	case "list":
+		err = node.ListGossipKeys(state.Config, state.Nodes)
+	case "rotate":
+		state.Config, err = node.RotateGossipKey(state.Config, state.Nodes)
+		if err == nil {
+			err = node.SaveState(state.Config, state.Nodes)
+		}
+	default:
+		err = errors.New("unknown keyring action " + action)
+	}
+	if err != nil {
+		run.Error("Keyring Failed")
+		run.Error(err.Error())
+		os.Exit(exitFailure)
+	}
+
+}
+
+func scale(cfg config.Config) {
+
+	// read the cluster state
+	state, err := node.LoadState(cfg)
+	if err != nil {
+		run.Error(err.Error())
+		os.Exit(exitFailure)
+	}
+
+	// an attached up owns its nodes
+	if err := node.CheckOwner(state); err != nil {
+		run.Error(err.Error())
+		os.Exit(exitFailure)
+	}
+
+	// the client count has to be asked for
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "clients" {
+			set = true
+		}
+	})
+	if !set || cfg.Clients < 0 {
+		run.Error("scale needs -clients")
+		os.Exit(exitFailure)
+	}
+
+	// grow or shrink and record what is left running
+	state.Config.ReadyTimeout = cfg.ReadyTimeout
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	state.Config, state.Nodes, err = node.ScaleNodes(ctx, state.Config, state.Nodes, cfg.Clients)
+	saveState(state)
+	if err != nil {
+		run.Error("Scale Failed")
+		run.Error(err.Error())
+		os.Exit(exitFailure)
+	}
+
+}
+
+func addNode(cfg config.Config) {
+
+	// read the cluster state
+	state, err := node.LoadState(cfg)
+	if err != nil {
+		run.Error(err.Error())
+		os.Exit(exitFailure)
+	}
+
+	// an attached up owns its nodes
+	if err := node.CheckOwner(state); err != nil {
+		run.Error(err.Error())
+		os.Exit(exitFailure)
+	}
+
+	// optional client group name
+	group := ""
+	if len(cfg.Args) > 0 {
+		group = cfg.Args[0]
+	}
+
+	state.Config.ReadyTimeout = cfg.ReadyTimeout
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	state.Config, state.Nodes, err = node.AddNode(ctx, state.Config, state.Nodes, group)
+	saveState(state)
+	if err != nil {
+		run.Error("Add Node Failed")
+		run.Error(err.Error())
+		os.Exit(exitFailure)
+	}
+
+}
+
+func removeNode(cfg config.Config) {
+
+	// read the cluster state
+	state, err := node.LoadState(cfg)
+	if err != nil {
+		run.Error(err.Error())
+		os.Exit(exitFailure)
+	}
+
+	// an attached up owns its nodes
+	if err := node.CheckOwner(state); err != nil {
+		run.Error(err.Error())
+		os.Exit(exitFailure)
+	}
+
+	// resolve the node
+	if len(cfg.Args) == 0 {
+		run.Error("remove-node needs a node name")
+		os.Exit(exitFailure)
+	}
+	i, err := node.FindNode(state.Config, state.Nodes, cfg.Args[0])
+	if err != nil {
+		run.Error(err.Error())
+		os.Exit(exitFailure)
+	}
+
+	state.Config.ReadyTimeout = cfg.ReadyTimeout
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	state.Config, state.Nodes, err = node.RemoveNode(ctx, state.Config, state.Nodes, i)
+	saveState(state)
+	if err != nil {
+		run.Error("Remove Node Failed")
+		run.Error(err.Error())
+		os.Exit(exitFailure)
+	}
+
+}
+
+func drain(cfg config.Config) {
+
+	// read the cluster state
+	state, err := node.LoadState(cfg)
+	if err != nil {
+		run.Error(err.Error())
+		os.Exit(exitFailure)
+	}
+
+	// resolve the node
+	if len(cfg.Args) == 0 {
+		run.Error("drain needs a node name")
+		os.Exit(exitFailure)
+	}
+	i, err := node.FindNode(state.Config, state.Nodes, cfg.Args[0])
+	if err != nil {
+		run.Error(err.Error())
+		os.Exit(exitFailure)
+	}
+
+	// drain or cancel one
+	if len(cfg.Args) > 1 && cfg.Args[1] == "off" {
+		err = node.CancelDrain(state.Config, state.Nodes, i)
+	} else {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		err = node.DrainNode(ctx, state.Config, state.Nodes, i, cfg.Deadline, cfg.Wait)
+		stop()
+	}
+	if err != nil {
+		run.Error("Drain Failed")
+		run.Error(err.Error())
+		os.Exit(exitFailure)
+	}
+
+}
+
+func eligibility(cfg config.Config) {
+
+	// read the cluster state
+	state, err := node.LoadState(cfg)
+	if err != nil {
+		run.Error(err.Error())
+		os.Exit(exitFailure)
+	}
+
+	// resolve the node and setting
+	if len(cfg.Args) < 2 || (cfg.Args[1] != "on" && cfg.Args[1] != "off") {
+		run.Error("eligibility needs a node name and on or off")
+		os.Exit(exitFailure)
+	}
+	i, err := node.FindNode(state.Config, state.Nodes, cfg.Args[0])
+	if err != nil {
+		run.Error(err.Error())
+		os.Exit(exitFailure)
+	}
+
+	if err := node.EligibilityNode(state.Config, state.Nodes, i, cfg.Args[1] == "on"); err != nil {
+		run.Error("Eligibility Failed")
+		run.Error(err.Error())
+		os.Exit(exitFailure)
+	}
+
+}
 
+// exitCode maps a failure bringing up the cluster to the phase it failed in
+func exitCode(err error) int {
+	var phase *node.PhaseError
+	if !errors.As(err, &phase) {
+		return exitFailure
+	}
+	switch phase.Phase {
+	case node.PhaseNetwork:
+		return exitNetwork
+	case node.PhaseLaunch:
+		return exitLaunch
+	case node.PhaseReady:
+		return exitReady
+	}
+	return exitFailure
+}
+
+// cleanNodes reports what could not be cleaned, the caller decides the exit
+func cleanNodes(cfg config.Config, nodes []node.Node) error {
+	err := node.CleanNodes(cfg, nodes)
+	if err != nil {
+		run.Error("Cannot Clean Nodes")
+		run.Error(err.Error())
+	}
+	return err
+}
+
+func saveState(state node.State) {
+	if err := node.SaveState(state.Config, state.Nodes); err != nil {
+		run.Error("Cannot Save State")
+		run.Error(err.Error())
+	}
 }
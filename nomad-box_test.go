@@ -0,0 +1,28 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/mmcquillan/nomad-box/node"
+)
+
+func TestExitCode(t *testing.T) {
+	cases := []struct {
+		err  error
+		want int
+	}{
+		{errors.New("cluster already running"), exitFailure},
+		{&node.PhaseError{Phase: node.PhaseNetwork, Node: "nmds0", Err: errors.New("no room")}, exitNetwork},
+		{&node.PhaseError{Phase: node.PhaseLaunch, Node: "nmdc0", Err: errors.New("bad template")}, exitLaunch},
+		{&node.PhaseError{Phase: node.PhaseReady, Err: errors.New("no leader")}, exitReady},
+		{fmt.Errorf("up: %w", &node.PhaseError{Phase: node.PhaseNetwork, Err: errors.New("no room")}), exitNetwork},
+		{&node.PhaseError{Phase: "other", Err: errors.New("unknown")}, exitFailure},
+	}
+	for _, c := range cases {
+		if got := exitCode(c.err); got != c.want {
+			t.Errorf("exitCode(%v) = %d, want %d", c.err, got, c.want)
+		}
+	}
+}
@@ -1,19 +1,26 @@
 package run
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Output is where nomad-box reports, io.Discard keeps a library user quiet
+var Output io.Writer = os.Stdout
 
 func Out(msg string) {
-	fmt.Printf("[NMD-BOX] %s\n", msg)
+	fmt.Fprintf(Output, "[NMD-BOX] %s\n", msg)
 }
 
 func Error(msg string) {
-	fmt.Printf("[NMD-BOX]    ERROR => %s\n", msg)
+	fmt.Fprintf(Output, "[NMD-BOX]    ERROR => %s\n", msg)
 }
 
 func Warn(msg string) {
-	fmt.Printf("[NMD-BOX]    WARN => %s\n", msg)
+	fmt.Fprintf(Output, "[NMD-BOX]    WARN => %s\n", msg)
 }
 
 func Header(msg string) {
-	fmt.Printf("[NMD-BOX] ===== %s =====\n", msg)
+	fmt.Fprintf(Output, "[NMD-BOX] ===== %s =====\n", msg)
 }
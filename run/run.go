@@ -3,108 +3,147 @@ package run
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"io"
+	"os"
 	"os/exec"
 	"strings"
-	"time"
+	"syscall"
 
 	"github.com/shirou/gopsutil/v3/process"
 )
 
-func Command(command string) {
-	cmd := exec.Command("bash", "-c", command)
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		Error("Running: " + command)
-		Error(err.Error())
-	}
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		Error("Running: " + command)
-		Error(err.Error())
-	}
-	if err := cmd.Start(); err != nil {
-		Error("Running: " + command)
-		Error(err.Error())
-	}
-	o := ReaderToString(stdout)
-	if o != "" {
-		Warn(o)
-	}
-	e := ReaderToString(stderr)
-	if e != "" {
-		Warn(e)
+// CommandError is a command that could not start or exited non zero
+type CommandError struct {
+	Command  string
+	ExitCode int
+	Stderr   string
+	Err      error
+}
+
+func (e *CommandError) Error() string {
+	msg := e.Command + ": " + e.Err.Error()
+	if e.Stderr != "" {
+		msg += ": " + e.Stderr
 	}
-	err = cmd.Wait()
-	if e != "" {
-		Warn(e)
+	return msg
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// Or is r, or a Shell when no runner was given
+func Or(r Runner) Runner {
+	if r == nil {
+		return Shell{}
 	}
+	return r
+}
+
+func Command(r Runner, command string) error {
+	_, err := Or(r).Output(command)
+	return err
+}
+
+func CommandContains(r Runner, command string, match string) (bool, error) {
+	out, err := Or(r).Output(command)
+	return strings.Contains(out, match), err
 }
 
-func CommandContains(command string, match string) bool {
+func (Shell) Output(command string) (string, error) {
 	cmd := exec.Command("bash", "-c", command)
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		Error("Running: " + command)
-		Error(err.Error())
-	}
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		Error("Running: " + command)
-		Error(err.Error())
-	}
-	if err := cmd.Start(); err != nil {
-		Error("Running: " + command)
-		Error(err.Error())
-	}
-	o := ReaderToString(stdout)
-	e := ReaderToString(stderr)
-	if e != "" {
-		Warn(e)
-	}
-	err = cmd.Wait()
-	if e != "" {
-		Warn(e)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		code := -1
+		var exit *exec.ExitError
+		if errors.As(err, &exit) {
+			code = exit.ExitCode()
+		}
+		return stdout.String(), &CommandError{Command: command, ExitCode: code, Stderr: strings.TrimSpace(stderr.String()), Err: err}
 	}
-	return strings.Contains(o, match)
+	return stdout.String(), nil
 }
 
-func Process(command string, prefix string, log bool) (pid int) {
+func (Shell) Process(command string, prefix string, log bool, logFile string) (pid int, exit chan int, err error) {
+	exit = make(chan int, 1)
+	file, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return pid, exit, err
+	}
 	cmd := exec.Command("bash", "-c", command)
 	out, err := cmd.StdoutPipe()
 	if err != nil {
-		Error("Running: " + command)
-		Error(err.Error())
+		file.Close()
+		return pid, exit, &CommandError{Command: command, ExitCode: -1, Err: err}
 	}
 	// combine stderr + stdout (guess this wokrs)
 	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		file.Close()
+		return pid, exit, &CommandError{Command: command, ExitCode: -1, Err: err}
+	}
 	done := make(chan struct{})
 	scanner := bufio.NewScanner(out)
 	go func() {
 		for scanner.Scan() {
 			line := scanner.Text()
+			file.WriteString(line + "\n")
 			if log {
 				Out("[" + prefix + "] " + line)
 			}
 		}
+		file.Close()
 		done <- struct{}{}
 	}()
-	if err := cmd.Start(); err != nil {
-		Error("Running " + command)
-		Error(err.Error())
-	}
 	go func() {
 		<-done
-		err = cmd.Wait()
-		if err != nil {
-			Warn(command)
-			Warn(err.Error())
-		}
+		cmd.Wait()
+		exit <- cmd.ProcessState.ExitCode()
 	}()
-	for cmd.Process.Pid == 0 {
-		time.Sleep(2 * time.Second)
+	return cmd.Process.Pid, exit, nil
+}
+
+func (Shell) Daemon(command string, logFile string) (pid int, err error) {
+	log, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return pid, err
+	}
+	defer log.Close()
+	cmd := exec.Command("bash", "-c", command)
+	cmd.Stdout = log
+	cmd.Stderr = log
+	// own session so the agent outlives nomad-box
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return pid, &CommandError{Command: command, ExitCode: -1, Err: err}
+	}
+	pid = cmd.Process.Pid
+	// reap it if it exits while we are still running
+	go cmd.Wait()
+	return pid, nil
+}
+
+func (Shell) WriteFile(path string, data []byte, perm os.FileMode) error {
+	if err := os.WriteFile(path, data, perm); err != nil {
+		return err
+	}
+	// an existing file keeps its mode otherwise
+	return os.Chmod(path, perm)
+}
+
+func (Shell) AppendFile(path string, data []byte, perm os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
 	}
-	return cmd.Process.Pid
+	return file.Close()
 }
 
 func CheckProcess(pid int) bool {
@@ -0,0 +1,85 @@
+package run
+
+import (
+	"os"
+	"sync"
+)
+
+// Runner carries out every command and file write, a config carries one to record or print them instead
+type Runner interface {
+	Output(command string) (string, error)
+	Process(command string, prefix string, log bool, logFile string) (pid int, exit chan int, err error)
+	Daemon(command string, logFile string) (pid int, err error)
+	WriteFile(path string, data []byte, perm os.FileMode) error
+	AppendFile(path string, data []byte, perm os.FileMode) error
+}
+
+// Shell runs commands with bash and writes files to disk
+type Shell struct{}
+
+// Step is a command or file write as a Recorder saw it
+type Step struct {
+	Command string `json:"command,omitempty"`
+	Log     string `json:"log,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Mode    string `json:"mode,omitempty"`
+	Content string `json:"content,omitempty"`
+	Append  bool   `json:"append,omitempty"`
+}
+
+// Recorder runs nothing and keeps the commands and files in order so tests can check them
+type Recorder struct {
+	mu       sync.Mutex
+	Commands []string
+	Steps    []Step
+	Files    map[string][]byte
+	Outputs  map[string]string
+}
+
+func (r *Recorder) Output(command string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Commands = append(r.Commands, command)
+	r.Steps = append(r.Steps, Step{Command: command})
+	return r.Outputs[command], nil
+}
+
+func (r *Recorder) Process(command string, prefix string, log bool, logFile string) (int, chan int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Commands = append(r.Commands, command)
+	r.Steps = append(r.Steps, Step{Command: command, Log: logFile})
+	return 0, make(chan int, 1), nil
+}
+
+func (r *Recorder) Daemon(command string, logFile string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Commands = append(r.Commands, command)
+	r.Steps = append(r.Steps, Step{Command: command, Log: logFile})
+	return 0, nil
+}
+
+func (r *Recorder) WriteFile(path string, data []byte, perm os.FileMode) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Files == nil {
+		r.Files = make(map[string][]byte)
+	}
+	r.Commands = append(r.Commands, "write "+path)
+	r.Steps = append(r.Steps, Step{Path: path, Mode: perm.String(), Content: string(data)})
+	r.Files[path] = data
+	return nil
+}
+
+func (r *Recorder) AppendFile(path string, data []byte, perm os.FileMode) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Files == nil {
+		r.Files = make(map[string][]byte)
+	}
+	r.Commands = append(r.Commands, "append "+path)
+	r.Steps = append(r.Steps, Step{Path: path, Mode: perm.String(), Content: string(data), Append: true})
+	r.Files[path] = append(r.Files[path], data...)
+	return nil
+}